@@ -0,0 +1,190 @@
+package rex
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AccessLogFormat is the format of the access log.
+type AccessLogFormat int
+
+const (
+	// AccessLogDefault is the default format of rex:
+	// `ip host proto method uri bytes_in referer "user_agent" status bytes_out latency`.
+	AccessLogDefault AccessLogFormat = iota
+	// AccessLogCommon is the Apache Common Log Format.
+	AccessLogCommon
+	// AccessLogCombined is the Apache Combined Log Format.
+	AccessLogCombined
+	// AccessLogJSON prints a JSON object per request.
+	AccessLogJSON
+	// AccessLogSlog logs the requests as the slog records with typed attributes.
+	AccessLogSlog
+)
+
+// AccessLogOptions contains the options for the access logger.
+type AccessLogOptions struct {
+	// Format is the format of the access log.
+	Format AccessLogFormat
+	// Slog is the logger of the AccessLogSlog format, default is the slog.Default().
+	Slog *slog.Logger
+	// SampleRate is the fraction of the requests to log, in the range (0, 1].
+	// All requests are logged if it's zero, the server errors are always logged.
+	SampleRate float64
+	// Exclude is a list of paths that are not logged, a path ending with '*'
+	// matches the paths with the prefix.
+	Exclude []string
+}
+
+// accessLog is the access logger of a request.
+type accessLog struct {
+	logger  ILogger
+	slog    *slog.Logger
+	format  AccessLogFormat
+	sampled bool
+}
+
+// newAccessLogHandle returns a middleware that sets the access logger.
+func newAccessLogHandle(logger ILogger, opts AccessLogOptions) Handle {
+	if opts.Format == AccessLogSlog && opts.Slog == nil {
+		opts.Slog = slog.Default()
+	}
+	return func(ctx *Context) any {
+		path := ctx.R.URL.Path
+		for _, p := range opts.Exclude {
+			if p == path || (strings.HasSuffix(p, "*") && strings.HasPrefix(path, p[:len(p)-1])) {
+				ctx.accessLog = nil
+				return next
+			}
+		}
+		ctx.accessLog = &accessLog{
+			logger:  logger,
+			slog:    opts.Slog,
+			format:  opts.Format,
+			sampled: opts.SampleRate <= 0 || opts.SampleRate >= 1 || rand.Float64() < opts.SampleRate,
+		}
+		return next
+	}
+}
+
+// log prints the access log of the request.
+func (l *accessLog) log(ctx *Context, status int, written int, startTime time.Time) {
+	if !l.sampled && status < 500 {
+		return
+	}
+	r := ctx.R
+	latency := time.Since(startTime)
+	bytesIn := r.ContentLength
+	if bytesIn < 0 {
+		bytesIn = 0
+	}
+	user := ctx.BasicAuthUser()
+
+	switch l.format {
+	case AccessLogSlog:
+		if l.slog == nil {
+			return
+		}
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		l.slog.LogAttrs(context.Background(), level, "access",
+			slog.String("remote_ip", ctx.RemoteIP()),
+			slog.String("method", r.Method),
+			slog.String("host", r.Host),
+			slog.String("uri", r.RequestURI),
+			slog.String("route", ctx.RoutePattern()),
+			slog.String("proto", r.Proto),
+			slog.Int("status", status),
+			slog.Int64("bytes_in", bytesIn),
+			slog.Int("bytes_out", written),
+			slog.Int64("latency_us", latency.Microseconds()),
+			slog.String("user", user),
+			slog.String("request_id", ctx.requestID),
+			slog.String("trace_id", ctx.TraceID()),
+			slog.String("referer", r.Referer()),
+			slog.String("user_agent", r.UserAgent()),
+		)
+		return
+	}
+
+	if l.logger == nil {
+		return
+	}
+	switch l.format {
+	case AccessLogCommon, AccessLogCombined:
+		if user == "" {
+			user = "-"
+		}
+		size := "-"
+		if written > 0 {
+			size = strconv.Itoa(written)
+		}
+		line := ctx.RemoteIP() + " - " + user + " [" + startTime.Format("02/Jan/2006:15:04:05 -0700") + `] "` +
+			r.Method + " " + escapeLogValue(r.RequestURI) + " " + r.Proto + `" ` + strconv.Itoa(status) + " " + size
+		if l.format == AccessLogCombined {
+			ref := r.Referer()
+			if ref == "" {
+				ref = "-"
+			}
+			line += ` "` + escapeLogValue(ref) + `" "` + escapeLogValue(r.UserAgent()) + `"`
+		}
+		l.logger.Printf("%s", line)
+	case AccessLogJSON:
+		data, _ := json.Marshal(map[string]any{
+			"time":       startTime.Format(time.RFC3339Nano),
+			"remote_ip":  ctx.RemoteIP(),
+			"method":     r.Method,
+			"host":       r.Host,
+			"uri":        r.RequestURI,
+			"route":      ctx.RoutePattern(),
+			"proto":      r.Proto,
+			"status":     status,
+			"bytes_in":   bytesIn,
+			"bytes_out":  written,
+			"latency_us": latency.Microseconds(),
+			"user":       user,
+			"request_id": ctx.requestID,
+			"trace_id":   ctx.TraceID(),
+			"referer":    r.Referer(),
+			"user_agent": r.UserAgent(),
+		})
+		l.logger.Printf("%s", data)
+	default:
+		ref := r.Referer()
+		if ref == "" {
+			ref = "-"
+		}
+		format := `%s %s %s %s %s %d %s "%s" %d %d %.3fms`
+		args := []any{
+			ctx.RemoteIP(),
+			r.Host,
+			r.Proto,
+			r.Method,
+			r.RequestURI,
+			r.ContentLength,
+			ref,
+			strings.ReplaceAll(r.UserAgent(), `"`, `\"`),
+			status,
+			written,
+			float64(latency.Microseconds()) / 1000,
+		}
+		if ctx.requestID != "" {
+			format += " %s"
+			args = append(args, ctx.requestID)
+		}
+		l.logger.Printf(format, args...)
+	}
+}
+
+// escapeLogValue escapes the quotes and control characters of a log value.
+func escapeLogValue(s string) string {
+	q := strconv.Quote(s)
+	return q[1 : len(q)-1]
+}
@@ -0,0 +1,515 @@
+package rex
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Bind decodes the request into the struct pointed to by v and validates it.
+//
+// The body decoder is picked by the Content-Type header: JSON bodies are decoded
+// with the `json` tags, form-urlencoded and multipart bodies are decoded with the
+// `form` tags (the `json` tag name or the field name is used if there is no `form` tag).
+// Fields tagged with `query`, `path` or `header` are filled from the query string,
+// the path wildcards or the request headers.
+//
+// Fields are validated by the `validate` tag, for example:
+//
+//	type User struct {
+//		ID    int    `path:"id"`
+//		Name  string `json:"name" validate:"required,min=2,max=32"`
+//		Email string `json:"email" validate:"required,email"`
+//		Role  string `json:"role" validate:"oneof=admin editor viewer"`
+//		Slug  string `json:"slug" validate:"regexp=^[a-z0-9-]+$"`
+//	}
+//
+// Rules other than `required` are skipped for zero values, the `regexp` rule
+// must be the last one since the pattern may contain commas.
+//
+// The body size is limited by the MaxBodySize middleware (32MB by default).
+// Bind returns an *Error with status code 400, 413, 415 or 422 if the request is invalid.
+func (ctx *Context) Bind(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("rex: Bind requires a non-nil pointer to a struct")
+	}
+	info := getStructInfo(rv.Elem().Type())
+	fields := map[string]string{}
+
+	form, err := ctx.decodeBody(v)
+	if err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			fields[typeErr.Field] = "must be " + kindName(typeErr.Type)
+		} else {
+			return err
+		}
+	}
+
+	elem := rv.Elem()
+	for _, f := range info.fields {
+		var values []string
+		var files []*multipart.FileHeader
+		var name string
+		switch {
+		case f.path != "":
+			name = f.path
+			if value := ctx.R.PathValue(f.path); value != "" {
+				values = []string{value}
+			}
+		case f.query != "":
+			name = f.query
+			values = ctx.Query()[f.query]
+		case f.header != "":
+			name = f.header
+			values = ctx.R.Header.Values(f.header)
+		case form != nil && f.form != "-":
+			name = f.form
+			values = form.Value[f.form]
+			files = form.File[f.form]
+		default:
+			continue
+		}
+		if len(values) == 0 && len(files) == 0 {
+			continue
+		}
+		fv := elem.FieldByIndex(f.index)
+		if len(files) > 0 {
+			if !setFileField(fv, files) {
+				fields[name] = "must be a file"
+			}
+			continue
+		}
+		if err := setField(fv, values); err != nil {
+			fields[name] = "must be " + kindName(fv.Type())
+		}
+	}
+	if len(fields) > 0 {
+		return &Error{Code: 400, Message: "invalid request", Fields: fields}
+	}
+
+	validateStruct(elem, "", fields)
+	if len(fields) > 0 {
+		return &Error{Code: 422, Message: "validation failed", Fields: fields}
+	}
+	return nil
+}
+
+// BindAs decodes the request into a new value of type T and validates it.
+// See [Context.Bind] for details.
+func BindAs[T any](ctx *Context) (T, error) {
+	var v T
+	err := ctx.Bind(&v)
+	return v, err
+}
+
+// decodeBody decodes the request body into v, it returns the parsed form
+// if the body is a form.
+func (ctx *Context) decodeBody(v any) (*multipart.Form, error) {
+	r := ctx.R
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return nil, nil
+	}
+	limit := ctx.maxBodySize
+	if limit <= 0 {
+		limit = defaultMaxBodySize
+	}
+	r.Body = http.MaxBytesReader(ctx.W, r.Body, limit)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		err := json.NewDecoder(r.Body).Decode(v)
+		if err != nil && err != io.EOF {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				return nil, err
+			}
+			return nil, bodyError(err, "invalid JSON body")
+		}
+		return nil, nil
+
+	case mediaType == "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return nil, bodyError(err, "invalid form body")
+		}
+		return &multipart.Form{Value: r.PostForm}, nil
+
+	case mediaType == "multipart/form-data":
+		if err := r.ParseMultipartForm(limit); err != nil {
+			return nil, bodyError(err, "invalid multipart body")
+		}
+		return r.MultipartForm, nil
+
+	default:
+		return nil, &Error{Code: 415, Message: http.StatusText(415)}
+	}
+}
+
+// bodyError converts the body decoding error to an *Error.
+func bodyError(err error, message string) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &Error{Code: 413, Message: http.StatusText(413)}
+	}
+	return &Error{Code: 400, Message: message}
+}
+
+var textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+var fileHeaderType = reflect.TypeFor[*multipart.FileHeader]()
+
+// setField sets the field value from the string values.
+func setField(fv reflect.Value, values []string) error {
+	if reflect.PointerTo(fv.Type()).Implements(textUnmarshalerType) {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(values[0]))
+	}
+	switch fv.Kind() {
+	case reflect.Pointer:
+		ptr := reflect.New(fv.Type().Elem())
+		if err := setField(ptr.Elem(), values); err != nil {
+			return err
+		}
+		fv.Set(ptr)
+		return nil
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() == reflect.Uint8 {
+			fv.SetBytes([]byte(values[0]))
+			return nil
+		}
+		s := reflect.MakeSlice(fv.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setField(s.Index(i), []string{value}); err != nil {
+				return err
+			}
+		}
+		fv.Set(s)
+		return nil
+	}
+	return setScalar(fv, values[0])
+}
+
+// setScalar sets the scalar field value from the string.
+func setScalar(fv reflect.Value, value string) error {
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if fv.Type() == reflect.TypeFor[time.Duration]() {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			fv.SetInt(int64(d))
+			return nil
+		}
+		i, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+	return nil
+}
+
+// setFileField sets the multipart file headers to the field.
+func setFileField(fv reflect.Value, files []*multipart.FileHeader) bool {
+	switch {
+	case fv.Type() == fileHeaderType:
+		fv.Set(reflect.ValueOf(files[0]))
+	case fv.Kind() == reflect.Slice && fv.Type().Elem() == fileHeaderType:
+		fv.Set(reflect.ValueOf(files))
+	default:
+		return false
+	}
+	return true
+}
+
+// kindName returns a human readable name of the type.
+func kindName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if t == reflect.TypeFor[time.Duration]() {
+			return "a duration"
+		}
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	default:
+		return "a valid " + t.String()
+	}
+}
+
+// validateStruct validates the struct fields by the `validate` tags and
+// records the failures in the fields map.
+func validateStruct(sv reflect.Value, prefix string, fields map[string]string) {
+	info := getStructInfo(sv.Type())
+	for _, f := range info.fields {
+		fv := sv.FieldByIndex(f.index)
+		name := prefix + f.name
+		if msg := validateField(fv, f.rules); msg != "" {
+			fields[name] = msg
+			continue
+		}
+		for fv.Kind() == reflect.Pointer && !fv.IsNil() {
+			fv = fv.Elem()
+		}
+		switch fv.Kind() {
+		case reflect.Struct:
+			if fv.Type() != reflect.TypeFor[time.Time]() {
+				validateStruct(fv, name+".", fields)
+			}
+		case reflect.Slice, reflect.Array:
+			if t := fv.Type().Elem(); t.Kind() == reflect.Struct || (t.Kind() == reflect.Pointer && t.Elem().Kind() == reflect.Struct) {
+				for i := 0; i < fv.Len(); i++ {
+					ev := fv.Index(i)
+					if ev.Kind() == reflect.Pointer {
+						if ev.IsNil() {
+							continue
+						}
+						ev = ev.Elem()
+					}
+					validateStruct(ev, name+"["+strconv.Itoa(i)+"].", fields)
+				}
+			}
+		}
+	}
+}
+
+// validateField validates the field value by the rules, it returns the
+// failure message or an empty string if the value is valid.
+func validateField(fv reflect.Value, rules []rule) string {
+	if len(rules) == 0 {
+		return ""
+	}
+	if fv.IsZero() {
+		if rules[0].name == "required" {
+			return "is required"
+		}
+		return ""
+	}
+	for fv.Kind() == reflect.Pointer {
+		fv = fv.Elem()
+	}
+	for _, r := range rules {
+		switch r.name {
+		case "min", "max":
+			var n float64
+			isLen := false
+			switch fv.Kind() {
+			case reflect.String:
+				n, isLen = float64(utf8.RuneCountInString(fv.String())), true
+			case reflect.Slice, reflect.Array, reflect.Map:
+				n, isLen = float64(fv.Len()), true
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				n = float64(fv.Int())
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				n = float64(fv.Uint())
+			case reflect.Float32, reflect.Float64:
+				n = fv.Float()
+			default:
+				continue
+			}
+			if r.name == "min" && n < r.num {
+				if isLen {
+					return "length must be at least " + r.arg
+				}
+				return "must be at least " + r.arg
+			}
+			if r.name == "max" && n > r.num {
+				if isLen {
+					return "length must be at most " + r.arg
+				}
+				return "must be at most " + r.arg
+			}
+		case "email":
+			s := fmt.Sprint(fv.Interface())
+			addr, err := mail.ParseAddress(s)
+			if err != nil || addr.Address != s {
+				return "must be a valid email address"
+			}
+		case "oneof":
+			s := fmt.Sprint(fv.Interface())
+			ok := false
+			for _, option := range r.options {
+				if s == option {
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				return "must be one of " + strings.Join(r.options, ", ")
+			}
+		case "regexp":
+			if !r.re.MatchString(fmt.Sprint(fv.Interface())) {
+				return "must match the pattern " + r.arg
+			}
+		}
+	}
+	return ""
+}
+
+// rule is a parsed validation rule.
+type rule struct {
+	name    string
+	arg     string
+	num     float64
+	options []string
+	re      *regexp.Regexp
+}
+
+// parseRules parses the `validate` tag, the required rule is always put first.
+func parseRules(tag string) []rule {
+	var rules []rule
+	required := false
+	for tag != "" {
+		var item string
+		if strings.HasPrefix(tag, "regexp=") {
+			item, tag = tag, ""
+		} else {
+			item, tag, _ = strings.Cut(tag, ",")
+		}
+		name, arg, _ := strings.Cut(strings.TrimSpace(item), "=")
+		r := rule{name: name, arg: arg}
+		switch name {
+		case "":
+			continue
+		case "required":
+			required = true
+			continue
+		case "min", "max":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic("rex: invalid validate rule: " + item)
+			}
+			r.num = n
+		case "oneof":
+			r.options = strings.Fields(arg)
+		case "regexp":
+			r.re = regexp.MustCompile(arg)
+		case "email":
+		default:
+			panic("rex: unknown validate rule: " + name)
+		}
+		rules = append(rules, r)
+	}
+	if required {
+		rules = append([]rule{{name: "required"}}, rules...)
+	}
+	return rules
+}
+
+// structInfo contains the binding and validation information of a struct type.
+type structInfo struct {
+	fields []fieldInfo
+}
+
+// fieldInfo contains the binding and validation information of a struct field.
+type fieldInfo struct {
+	index  []int
+	name   string
+	form   string
+	query  string
+	path   string
+	header string
+	rules  []rule
+}
+
+var structInfoCache sync.Map // map[reflect.Type]*structInfo
+
+// getStructInfo returns the cached struct information of the type.
+func getStructInfo(t reflect.Type) *structInfo {
+	if v, ok := structInfoCache.Load(t); ok {
+		return v.(*structInfo)
+	}
+	info := &structInfo{}
+	collectFields(t, nil, info)
+	v, _ := structInfoCache.LoadOrStore(t, info)
+	return v.(*structInfo)
+}
+
+// collectFields collects the exported fields of the struct type including
+// the fields of the embedded structs.
+func collectFields(t reflect.Type, index []int, info *structInfo) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		idx := append(append([]int{}, index...), i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct && sf.Tag.Get("json") == "" {
+			collectFields(sf.Type, idx, info)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		jsonName, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		f := fieldInfo{
+			index:  idx,
+			form:   sf.Tag.Get("form"),
+			query:  sf.Tag.Get("query"),
+			path:   sf.Tag.Get("path"),
+			header: sf.Tag.Get("header"),
+			rules:  parseRules(sf.Tag.Get("validate")),
+		}
+		if jsonName == "-" {
+			jsonName = ""
+		}
+		if f.form == "" {
+			f.form = jsonName
+			if f.form == "" {
+				f.form = sf.Name
+			}
+		}
+		for _, name := range []string{jsonName, f.path, f.query, f.header, f.form} {
+			if name != "" && name != "-" {
+				f.name = name
+				break
+			}
+		}
+		info.fields = append(info.fields, f)
+	}
+}
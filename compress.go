@@ -0,0 +1,174 @@
+package rex
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdWindowSize is the max window size of zstd for HTTP defined in RFC 8878.
+const zstdWindowSize = 8 << 20
+
+// CompressOptions contains the options for the CompressWithOptions middleware.
+type CompressOptions struct {
+	// Encodings are the supported encodings in the server preference order,
+	// default is ["br", "zstd", "gzip"]. The preference is used when the client
+	// accepts multiple encodings with the same quality value.
+	Encodings []string
+	// Levels are the compression levels by the encoding, the fastest level of
+	// the encoding is used if it's not set. The levels are in the range [0, 11]
+	// for br, [-2, 9] for gzip and [1, 22] for zstd.
+	Levels map[string]int
+	// MinSize is the min size of the content to compress, default is 1024.
+	MinSize int
+	// Types are the MIME types to compress, a type ending with "/*" matches all
+	// subtypes. Default is the text types, JavaScript, JSON, XML and WASM.
+	Types []string
+	// ExcludeTypes are the MIME types not to compress, a type ending with "/*"
+	// matches all subtypes.
+	ExcludeTypes []string
+}
+
+// compressEncoder is a pooled compression writer.
+type compressEncoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// compressor compresses the responses with the pooled encoders.
+type compressor struct {
+	encodings    []string
+	minSize      int
+	types        []string
+	excludeTypes []string
+	pools        map[string]*sync.Pool
+}
+
+var defaultCompressor = newCompressor(CompressOptions{})
+
+func newCompressor(opts CompressOptions) *compressor {
+	c := &compressor{
+		minSize:      opts.MinSize,
+		types:        normalizeMediaTypes(opts.Types),
+		excludeTypes: normalizeMediaTypes(opts.ExcludeTypes),
+		pools:        map[string]*sync.Pool{},
+	}
+	if c.minSize <= 0 {
+		c.minSize = compressMinSize
+	}
+	levels := make(map[string]int, len(opts.Levels))
+	for encoding, level := range opts.Levels {
+		levels[strings.ToLower(strings.TrimSpace(encoding))] = level
+	}
+	encodings := opts.Encodings
+	if len(encodings) == 0 {
+		encodings = []string{"br", "zstd", "gzip"}
+	}
+	for _, encoding := range encodings {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		level, hasLevel := levels[encoding]
+		var newEncoder func() compressEncoder
+		switch encoding {
+		case "br":
+			if !hasLevel {
+				level = brotli.BestSpeed
+			} else if level < brotli.BestSpeed || level > brotli.BestCompression {
+				panic("compress: invalid br level")
+			}
+			newEncoder = func() compressEncoder {
+				return brotli.NewWriterLevel(io.Discard, level)
+			}
+		case "gzip":
+			if !hasLevel {
+				level = gzip.BestSpeed
+			} else if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+				panic("compress: invalid gzip level")
+			}
+			newEncoder = func() compressEncoder {
+				w, _ := gzip.NewWriterLevel(io.Discard, level)
+				return w
+			}
+		case "zstd":
+			zlevel := zstd.SpeedFastest
+			if hasLevel {
+				if level < 1 || level > 22 {
+					panic("compress: invalid zstd level")
+				}
+				zlevel = zstd.EncoderLevelFromZstd(level)
+			}
+			newEncoder = func() compressEncoder {
+				w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zlevel), zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(zstdWindowSize))
+				return w
+			}
+		default:
+			panic("compress: unsupported encoding " + encoding)
+		}
+		if _, ok := c.pools[encoding]; !ok {
+			c.encodings = append(c.encodings, encoding)
+			c.pools[encoding] = &sync.Pool{New: func() any { return newEncoder() }}
+		}
+	}
+	return c
+}
+
+// negotiate returns the encoding with the highest quality value in the
+// Accept-Encoding header, or an empty string if none is acceptable.
+func (c *compressor) negotiate(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	list := parseQualityList(acceptEncoding)
+	best, bestQ := "", 0.0
+	for _, encoding := range c.encodings {
+		if q := encodingQuality(list, encoding); q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressible reports whether the content type should be compressed.
+func (c *compressor) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if matchMediaTypes(c.excludeTypes, mediaType) {
+		return false
+	}
+	if len(c.types) > 0 {
+		return matchMediaTypes(c.types, mediaType)
+	}
+	return isTextContent(mediaType) || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// encoder returns a pooled encoder of the encoding that writes to w.
+func (c *compressor) encoder(encoding string, w io.Writer) compressEncoder {
+	enc := c.pools[encoding].Get().(compressEncoder)
+	enc.Reset(w)
+	return enc
+}
+
+func normalizeMediaTypes(types []string) []string {
+	list := make([]string, 0, len(types))
+	for _, t := range types {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			list = append(list, t)
+		}
+	}
+	return list
+}
+
+func matchMediaTypes(types []string, mediaType string) bool {
+	for _, t := range types {
+		if t == mediaType || t == "*/*" || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1])) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,23 +2,23 @@ package rex
 
 import (
 	"bytes"
-	"compress/gzip"
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
 	"strconv"
 	"strings"
 	"time"
 	"unicode/utf8"
 
-	"github.com/andybalholm/brotli"
-	"github.com/ije/gox/utils"
 	"github.com/ije/rex/session"
 )
 
@@ -45,8 +45,20 @@ type Context struct {
 	sessionPool      session.Pool
 	sessionIdHandler session.SidHandler
 	logger           ILogger
-	accessLogger     ILogger
-	compress         bool
+	accessLog        *accessLog
+	compress         *compressor
+	maxBodySize      int64
+	mux              *Mux
+	writer           *rexWriter
+	handlingError    bool
+	forwarded        *forwarded
+	rest             continuation
+	result           any
+	onFinish         []func(status int, written int64)
+	requestID        string
+	etag             *ETagOptions
+	span             *Span
+	probe            *typeProbe
 }
 
 // Next executes the next middleware in the chain.
@@ -54,6 +66,36 @@ func (ctx *Context) Next() any {
 	return next
 }
 
+// OnFinish adds a hook that is called after the response is written with the
+// status code and the number of bytes written, the hooks are called in order.
+func (ctx *Context) OnFinish(fn func(status int, written int64)) {
+	if fn != nil {
+		ctx.onFinish = append(ctx.onFinish, fn)
+	}
+}
+
+// Context returns the context of the request, it's canceled when the client
+// disconnects or the deadline set by the Timeout middleware exceeds.
+func (ctx *Context) Context() context.Context {
+	return ctx.R.Context()
+}
+
+// Done returns a channel that's closed when the request context is canceled.
+func (ctx *Context) Done() <-chan struct{} {
+	return ctx.R.Context().Done()
+}
+
+// WithValue stores the value in the request context with the key, the value is
+// visible to the rest handles and the wrapped net/http handlers.
+func (ctx *Context) WithValue(key, value any) {
+	ctx.R = ctx.R.WithContext(context.WithValue(ctx.R.Context(), key, value))
+}
+
+// Value returns the value stored in the request context with the key.
+func (ctx *Context) Value(key any) any {
+	return ctx.R.Context().Value(key)
+}
+
 // Method returns the request method.
 func (ctx *Context) Method() string {
 	return ctx.R.Method
@@ -72,6 +114,32 @@ func (ctx *Context) PathValue(key string) string {
 	return ctx.R.PathValue(key)
 }
 
+// RequestID returns the request ID set by the RequestID middleware.
+func (ctx *Context) RequestID() string {
+	return ctx.requestID
+}
+
+// TraceID returns the trace ID set by the Trace middleware in hex,
+// it returns the empty string if the request is not traced.
+func (ctx *Context) TraceID() string {
+	if ctx.span == nil {
+		return ""
+	}
+	return hex.EncodeToString(ctx.span.TraceID[:])
+}
+
+// Span returns the server span of the request, it returns nil if the request
+// is not traced.
+func (ctx *Context) Span() *Span {
+	return ctx.span
+}
+
+// RoutePattern returns the pattern of the route that matched the request,
+// it returns the empty string if no route matched.
+func (ctx *Context) RoutePattern() string {
+	return ctx.R.Pattern
+}
+
 // RawQuery returns the request raw query string.
 func (ctx *Context) RawQuery() string {
 	return ctx.R.URL.RawQuery
@@ -124,19 +192,44 @@ func (ctx *Context) UserAgent() string {
 	return ctx.R.Header.Get("User-Agent")
 }
 
-// RemoteIP returns the remote client IP.
+// RemoteIP returns the remote client IP, the forwarding headers are used
+// only if the peer is a trusted proxy.
 func (ctx *Context) RemoteIP() string {
-	ip := ctx.R.Header.Get("X-Real-IP")
-	if ip == "" {
-		ip = ctx.R.Header.Get("X-Forwarded-For")
-		if ip != "" {
-			ip, _ = utils.SplitByFirstByte(ip, ',')
+	return ctx.forwardedInfo().ip
+}
+
+// Scheme returns the request scheme, "http" or "https". The X-Forwarded-Proto
+// header or the proto of the Forwarded header is used if the peer is a trusted proxy.
+func (ctx *Context) Scheme() string {
+	if proto := strings.ToLower(ctx.forwardedInfo().proto); proto == "http" || proto == "https" {
+		return proto
+	}
+	if ctx.R.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host returns the request host. The X-Forwarded-Host header or the host of
+// the Forwarded header is used if the peer is a trusted proxy.
+func (ctx *Context) Host() string {
+	if host := ctx.forwardedInfo().host; host != "" && !strings.ContainsAny(host, " /\\@") {
+		return host
+	}
+	return ctx.R.Host
+}
+
+func (ctx *Context) forwardedInfo() *forwarded {
+	if ctx.forwarded == nil {
+		var f forwarded
+		if ctx.mux != nil {
+			f = resolveForwarded(ctx.R, ctx.mux.trustedProxies)
 		} else {
-			ip = ctx.R.RemoteAddr
+			f = resolveForwarded(ctx.R, nil)
 		}
+		ctx.forwarded = &f
 	}
-	ip, _ = utils.SplitByLastByte(ip, ':')
-	return ip
+	return ctx.forwarded
 }
 
 // Set sets the header entries associated with key to the
@@ -210,35 +303,42 @@ func (ctx *Context) FormFile(key string) (multipart.File, *multipart.FileHeader,
 	return ctx.R.FormFile(key)
 }
 
-func (ctx *Context) enableCompression() bool {
-	var encoding string
-	accectEncoding := ctx.R.Header.Get("Accept-Encoding")
-	if accectEncoding != "" && strings.Contains(accectEncoding, "br") {
-		encoding = "br"
-	} else if accectEncoding != "" && strings.Contains(accectEncoding, "gzip") {
-		encoding = "gzip"
+// compressEncoding returns the encoding to compress the content of the type and
+// the size, the size is -1 if it's unknown. An empty string is returned if the
+// content should not be compressed.
+func (ctx *Context) compressEncoding(contentType string, size int) string {
+	c := ctx.compress
+	if c == nil || ctx.W.Header().Get("Content-Encoding") != "" {
+		return ""
 	}
-	if encoding != "" {
-		w, ok := ctx.W.(*rexWriter)
-		if ok {
-			h := w.Header()
-			if v := h.Get("Vary"); v == "" {
-				h.Set("Vary", "Accept-Encoding")
-			} else if !strings.Contains(v, "Accept-Encoding") && !strings.Contains(v, "accept-encoding") {
-				h.Set("Vary", v+", Accept-Encoding")
-			}
-			h.Set("Content-Encoding", encoding)
-			h.Del("Content-Length")
-			switch encoding {
-			case "br":
-				w.zWriter = brotli.NewWriterLevel(w.rawWriter, brotli.BestSpeed)
-			case "gzip":
-				w.zWriter, _ = gzip.NewWriterLevel(w.rawWriter, gzip.BestSpeed)
-			}
-			return true
-		}
+	if (size >= 0 && size < c.minSize) || !c.compressible(contentType) {
+		return ""
+	}
+	if _, ok := ctx.W.(*rexWriter); !ok {
+		return ""
 	}
-	return false
+	addVary(ctx.W.Header(), "Accept-Encoding")
+	return c.negotiate(ctx.R.Header.Get("Accept-Encoding"))
+}
+
+// enableCompression compresses the response with the encoding, the strong ETag
+// is tagged with the encoding since the compressed content is a different
+// representation.
+func (ctx *Context) enableCompression(encoding string) bool {
+	if encoding == "" {
+		return false
+	}
+	c := ctx.compress
+	w := ctx.W.(*rexWriter)
+	h := w.Header()
+	h.Set("Content-Encoding", encoding)
+	h.Del("Content-Length")
+	if etag := h.Get("ETag"); etag != "" {
+		h.Set("ETag", encodedETag(etag, encoding))
+	}
+	w.zWriter = c.encoder(encoding, w.rawWriter)
+	w.zPool = c.pools[encoding]
+	return true
 }
 
 func (ctx *Context) respondWith(v any) {
@@ -268,30 +368,34 @@ Route:
 		if h.Get("Content-Type") == "" {
 			h.Set("Content-Type", "text/plain; charset=utf-8")
 		}
-		if ctx.compress {
-			if len(data) < compressMinSize || !ctx.enableCompression() {
-				h.Set("Content-Length", strconv.Itoa(len(data)))
-			}
-		} else {
+		encoding := ctx.compressEncoding(h.Get("Content-Type"), len(data))
+		if ctx.checkPreconditions(code, data, encoding) {
+			return
+		}
+		if !ctx.enableCompression(encoding) {
 			h.Set("Content-Length", strconv.Itoa(len(data)))
 		}
 		w.WriteHeader(code)
 		w.Write(data)
 
 	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		data := fmt.Appendf(nil, "%v", r)
 		if h.Get("Content-Type") == "" {
 			h.Set("Content-Type", "text/plain")
 		}
+		if ctx.checkPreconditions(code, data, "") {
+			return
+		}
 		w.WriteHeader(code)
-		fmt.Fprintf(w, "%v", r)
+		w.Write(data)
 
 	case []byte:
 		cType := h.Get("Content-Type")
-		if ctx.compress && isTextContent(cType) {
-			if len(r) < compressMinSize || !ctx.enableCompression() {
-				h.Set("Content-Length", strconv.Itoa(len(r)))
-			}
-		} else {
+		encoding := ctx.compressEncoding(cType, len(r))
+		if ctx.checkPreconditions(code, r, encoding) {
+			return
+		}
+		if !ctx.enableCompression(encoding) {
 			h.Set("Content-Length", strconv.Itoa(len(r)))
 		}
 		if cType == "" {
@@ -322,16 +426,12 @@ Route:
 		if cType == "" {
 			h.Set("Content-Type", "binary/octet-stream")
 		}
-		if ctx.compress && isTextContent(cType) {
-			if size >= 0 {
-				if size < compressMinSize || !ctx.enableCompression() {
-					h.Set("Content-Length", strconv.Itoa(size))
-				}
-			} else {
-				// unable to seek, compress the content anyway
-				ctx.enableCompression()
-			}
-		} else if size >= 0 {
+		encoding := ctx.compressEncoding(h.Get("Content-Type"), size)
+		if ctx.checkPreconditions(code, nil, encoding) {
+			return
+		}
+		// the content is compressed anyway if it's unable to seek
+		if !ctx.enableCompression(encoding) && size >= 0 {
 			h.Set("Content-Length", strconv.Itoa(size))
 		}
 		w.WriteHeader(code)
@@ -342,10 +442,11 @@ Route:
 			defer c.Close()
 		}
 		size := -1
-		if s, ok := r.content.(io.Seeker); ok {
-			n, err := s.Seek(0, io.SeekEnd)
+		seeker, seekable := r.content.(io.Seeker)
+		if seekable {
+			n, err := seeker.Seek(0, io.SeekEnd)
 			if err == nil {
-				_, err = s.Seek(0, io.SeekStart)
+				_, err = seeker.Seek(0, io.SeekStart)
 				if err != nil {
 					ctx.respondWithError(err)
 					return
@@ -353,32 +454,16 @@ Route:
 				size = int(n)
 			}
 		}
-		if ctx.compress && isTextFile(r.name) {
-			if size >= 0 {
-				if size < compressMinSize || !ctx.enableCompression() {
-					h.Set("Content-Length", strconv.Itoa(int(size)))
-				}
-			} else {
-				// unable to seek, compress the content anyway
-				ctx.enableCompression()
-			}
-		} else if size >= 0 {
-			h.Set("Content-Length", strconv.Itoa(size))
-		}
-		etag := h.Get("ETag")
-		if etag != "" && etag == ctx.R.Header.Get("If-None-Match") {
-			w.WriteHeader(304)
-			return
-		}
+		var body []byte
 		if r.mtime.IsZero() {
 			if h.Get("Cache-Control") == "" {
 				h.Set("Cache-Control", "public, max-age=0, must-revalidate")
 			}
-		} else {
-			if checkIfModifiedSince(ctx.R, r.mtime) {
-				w.WriteHeader(304)
-				return
+			if ctx.etag != nil && h.Get("ETag") == "" {
+				// generate the ETag of the Render and HTML output
+				body, _ = bufferedContent(r.content)
 			}
+		} else {
 			h.Set("Last-Modified", r.mtime.UTC().Format(http.TimeFormat))
 		}
 		ctype := h.Get("Content-Type")
@@ -388,6 +473,36 @@ Route:
 				h.Set("Content-Type", ctype)
 			}
 		}
+		if ctype == "" && isTextFile(r.name) {
+			// the text files without a registered MIME type
+			ctype = "text/plain"
+		}
+		encoding := ctx.compressEncoding(ctype, size)
+		if ctx.checkPreconditions(code, body, encoding) {
+			return
+		}
+		etag := h.Get("ETag")
+		if size >= 0 && code == 200 {
+			h.Set("Accept-Ranges", "bytes")
+			method := ctx.R.Method
+			if rh := ctx.R.Header.Get("Range"); rh != "" && (method == "GET" || method == "HEAD") && checkIfRange(ctx.R, etag, r.mtime) {
+				ranges, err := parseRange(rh, int64(size))
+				if err != nil {
+					h.Set("Content-Range", "bytes */"+strconv.Itoa(size))
+					w.WriteHeader(416)
+					return
+				}
+				if len(ranges) > 0 {
+					// the ranges are served uncompressed
+					ctx.respondWithRanges(r.content.(io.ReadSeeker), int64(size), ranges)
+					return
+				}
+			}
+		}
+		// the content is compressed anyway if it's unable to seek
+		if !ctx.enableCompression(encoding) && size >= 0 {
+			h.Set("Content-Length", strconv.Itoa(size))
+		}
 		w.WriteHeader(code)
 		if ctx.R.Method != "HEAD" {
 			io.Copy(w, r.content)
@@ -405,23 +520,10 @@ Route:
 			goto Route
 		}
 
-	case *fs:
-		filepath := path.Join(r.root, ctx.R.URL.Path)
-		fi, err := os.Stat(filepath)
-		if err == nil && fi.IsDir() {
-			filepath = path.Join(filepath, "index.html")
-			_, err = os.Stat(filepath)
-		}
-		if err != nil && os.IsNotExist(err) && !strings.HasSuffix(filepath, ".html") {
-			filepath = filepath + ".html"
-			fi, err = os.Stat(filepath)
-		}
-		if err != nil && os.IsNotExist(err) && r.fallback != "" {
-			filepath = path.Join(r.root, r.fallback)
-			fi, err = os.Stat(filepath)
-		}
+	case *fileServer:
+		name, file, fi, err := r.open(ctx.R.URL.Path)
 		if err != nil {
-			if os.IsNotExist(err) {
+			if errors.Is(err, fs.ErrNotExist) {
 				w.WriteHeader(404)
 				w.Write([]byte("Not Found"))
 			} else {
@@ -429,59 +531,146 @@ Route:
 			}
 			return
 		}
-		file, err := os.Open(filepath)
-		if err != nil {
-			ctx.respondWithError(err)
-			return
+		// the ranges are served from the original file
+		if ctx.R.Header.Get("Range") == "" {
+			encoding, pfile, pfi, exists := r.openPrecompressed(name, ctx.R.Header.Get("Accept-Encoding"))
+			if exists {
+				addVary(h, "Accept-Encoding")
+			}
+			if pfile != nil {
+				file.Close()
+				h.Set("Content-Encoding", encoding)
+				if etag := r.etag(name+path.Ext(pfi.Name()), pfi); etag != "" && h.Get("ETag") == "" {
+					h.Set("ETag", etag)
+				}
+				// auto closed
+				v = &content{path.Base(name), pfi.ModTime(), pfile}
+				goto Route
+			}
+		}
+		if etag := r.etag(name, fi); etag != "" && h.Get("ETag") == "" {
+			h.Set("ETag", etag)
 		}
 		// auto closed
-		v = &content{path.Base(filepath), fi.ModTime(), file}
+		v = &content{path.Base(name), fi.ModTime(), file}
 		goto Route
 
-	case *invalid:
-		h.Set("Content-Type", "text/plain; charset=utf-8")
-		w.WriteHeader(r.code)
-		w.Write([]byte(r.message))
+	case *sse:
+		r.serve(ctx)
 
-	case Error:
-		h.Set("Content-Type", "application/json; charset=utf-8")
-		w.WriteHeader(r.Code)
-		json.NewEncoder(w).Encode(r)
+	case *webSocket:
+		r.serve(ctx)
 
-	case *Error:
-		h.Set("Content-Type", "application/json; charset=utf-8")
-		w.WriteHeader(r.Code)
-		json.NewEncoder(w).Encode(r)
+	case *Problem:
+		ctx.respondWithProblem(r)
+
+	case Error:
+		ctx.respondWithError(&r)
 
 	case error:
 		ctx.respondWithError(r)
 
+	case *jsonValue:
+		ctx.respondWithJSON(code, r.value)
+
 	default:
-		buf := bytes.NewBuffer(nil)
-		err := json.NewEncoder(buf).Encode(v)
-		h.Set("Content-Type", "application/json; charset=utf-8")
-		if err != nil {
-			w.WriteHeader(500)
-			w.Write([]byte(`{"error": {"status": 500, "message": "bad json"}}`))
-			return
+		ctx.respondWithJSON(code, v)
+	}
+}
+
+func (ctx *Context) respondWithJSON(code int, v any) {
+	w := ctx.W
+	h := w.Header()
+	buf := bytes.NewBuffer(nil)
+	err := json.NewEncoder(buf).Encode(v)
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte(`{"error": {"status": 500, "message": "bad json"}}`))
+		return
+	}
+	encoding := ctx.compressEncoding("application/json", buf.Len())
+	if ctx.checkPreconditions(code, buf.Bytes(), encoding) {
+		return
+	}
+	if !ctx.enableCompression(encoding) {
+		h.Set("Content-Length", strconv.Itoa(buf.Len()))
+	}
+	w.WriteHeader(code)
+	io.Copy(w, buf)
+}
+
+// logf prints a message with the tag by the error logger, the request ID is
+// added if it's set.
+func (ctx *Context) logf(tag string, format string, v ...any) {
+	if ctx.logger == nil {
+		return
+	}
+	prefix := "[" + tag + "] "
+	if ctx.requestID != "" {
+		prefix += "[" + ctx.requestID + "] "
+	}
+	ctx.logger.Printf("%s"+format, append([]any{prefix}, v...)...)
+}
+
+// respondWithError replies to the request with the error, the error is passed to the
+// error handler of the mux if it's set.
+func (ctx *Context) respondWithError(err error) {
+	var e *Error
+	var pe *panicError
+	switch {
+	case errors.As(err, &e):
+	case errors.As(err, &pe):
+		// logged by the recover
+	default:
+		if e, ok := err.(*invalid); !ok || (e.code >= 500 && e.message != http.StatusText(e.code)) {
+			ctx.logf("error", "%s", err.Error())
+		}
+	}
+
+	if ctx.mux != nil && ctx.mux.errorHandler != nil && !ctx.handlingError {
+		ctx.handlingError = true
+		if v := ctx.mux.errorHandler(ctx, err); v != nil {
+			ctx.respondWith(v)
 		}
-		if !ctx.compress || buf.Len() < compressMinSize || !ctx.enableCompression() {
-			h.Set("Content-Length", strconv.Itoa(buf.Len()))
+		return
+	}
+
+	w := ctx.W
+	h := w.Header()
+	switch {
+	case e != nil:
+		h.Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(e.Code)
+		json.NewEncoder(w).Encode(e)
+	case pe != nil:
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(500)
+		w.Write([]byte("Internal Server Error"))
+	default:
+		code := errorStatusCode(err)
+		message := err.Error()
+		var p *Problem
+		if _, ok := err.(*invalid); !ok && !errors.As(err, &p) {
+			// the internal errors are logged, don't expose them to the client
+			message = "Internal Server Error"
+		} else if code >= 500 {
+			// the server errors may contain the paths or the internal messages
+			message = http.StatusText(code)
 		}
+		h.Set("Content-Type", "text/plain; charset=utf-8")
 		w.WriteHeader(code)
-		io.Copy(w, buf)
+		w.Write([]byte(message))
 	}
 }
 
-func (ctx *Context) respondWithError(err error) {
-	w := ctx.W
-	message := err.Error()
-	if ctx.logger != nil {
-		ctx.logger.Printf("[error] %s", message)
+// addVary adds the header name to the Vary header.
+func addVary(h http.Header, name string) {
+	if v := h.Get("Vary"); v == "" {
+		h.Set("Vary", name)
+	} else if !strings.Contains(strings.ToLower(v), strings.ToLower(name)) {
+		h.Set("Vary", v+", "+name)
 	}
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.WriteHeader(500)
-	w.Write([]byte(message))
 }
 
 func isTextFile(filename string) bool {
@@ -530,21 +719,3 @@ func hexEscapeNonASCII(s string) string {
 	}
 	return string(b)
 }
-
-func checkIfModifiedSince(r *http.Request, modtime time.Time) bool {
-	if r.Method != "GET" && r.Method != "HEAD" {
-		return false
-	}
-	ims := r.Header.Get("If-Modified-Since")
-	if ims == "" || modtime.IsZero() || modtime.Equal(time.Unix(0, 0)) {
-		return false
-	}
-	t, err := http.ParseTime(ims)
-	if err != nil {
-		return false
-	}
-	// The Last-Modified header truncates sub-second precision so
-	// the modtime needs to be truncated too.
-	modtime = modtime.Truncate(time.Second)
-	return modtime.Compare(t) > 0
-}
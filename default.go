@@ -2,17 +2,20 @@ package rex
 
 import (
 	"log"
+	"net/netip"
 	"time"
 
 	"github.com/ije/rex/session"
 )
 
 const compressMinSize = 1024
+const defaultMaxBodySize = 32 << 20
+const defaultShutdownTimeout = 30 // seconds
 
 var defaultMux = New()
 var defaultSessionPool = session.NewMemorySessionPool(time.Hour / 2)
 var defaultSessionIdHandler = session.NewCookieSidHandler("SID")
-var defaultLogger = &log.Logger{}
+var defaultLogger = log.Default()
 
 // Use appends middlewares to current APIS middleware stack.
 func Use(middlewares ...Handle) {
@@ -20,36 +23,77 @@ func Use(middlewares ...Handle) {
 }
 
 // AddRoute adds a route.
-func AddRoute(pattern string, handle Handle) {
-	defaultMux.AddRoute(pattern, handle)
+func AddRoute(pattern string, handle Handle) *Route {
+	return defaultMux.AddRoute(pattern, handle)
+}
+
+// OpenAPI generates the OpenAPI document from the registered routes.
+func OpenAPI(info OpenAPIInfo) *OpenAPIDocument {
+	return defaultMux.OpenAPI(info)
+}
+
+// OpenAPIHandler returns a Handle that replies with the OpenAPI document.
+func OpenAPIHandler(info OpenAPIInfo) Handle {
+	return defaultMux.OpenAPIHandler(info)
+}
+
+// NotFound sets the handle to reply the requests that match no routes.
+func NotFound(handle Handle) {
+	defaultMux.NotFound(handle)
+}
+
+// MethodNotAllowed sets the handle to reply the requests whose path matches
+// a route but the method doesn't.
+func MethodNotAllowed(handle Handle) {
+	defaultMux.MethodNotAllowed(handle)
+}
+
+// ErrorHandler sets the handler to reply the errors.
+func ErrorHandler(handler func(ctx *Context, err error) any) {
+	defaultMux.ErrorHandler(handler)
+}
+
+// TrustedProxies sets the networks of the trusted proxies.
+func TrustedProxies(prefixes []netip.Prefix) {
+	defaultMux.TrustedProxies(prefixes)
+}
+
+// Group returns a new route group with the given prefix and middlewares.
+func Group(prefix string, middlewares ...Handle) *RouteGroup {
+	return defaultMux.Group(prefix, middlewares...)
 }
 
 // HEAD returns a Handle to handle HEAD requests
-func HEAD(pattern string, handles ...Handle) {
-	AddRoute("HEAD "+pattern, Chain(handles...))
+func HEAD(pattern string, handles ...Handle) *Route {
+	return defaultMux.HEAD(pattern, handles...)
 }
 
 // GET returns a Handle to handle GET requests
-func GET(pattern string, handles ...Handle) {
-	AddRoute("GET "+pattern, Chain(handles...))
+func GET(pattern string, handles ...Handle) *Route {
+	return defaultMux.GET(pattern, handles...)
 }
 
 // POST returns a Handle to handle POST requests
-func POST(pattern string, handles ...Handle) {
-	AddRoute("POST "+pattern, Chain(handles...))
+func POST(pattern string, handles ...Handle) *Route {
+	return defaultMux.POST(pattern, handles...)
 }
 
 // PUT returns a Handle to handle PUT requests
-func PUT(pattern string, handles ...Handle) {
-	AddRoute("PUT "+pattern, Chain(handles...))
+func PUT(pattern string, handles ...Handle) *Route {
+	return defaultMux.PUT(pattern, handles...)
 }
 
 // PATCH returns a Handle to handle PATCH requests
-func PATCH(pattern string, handles ...Handle) {
-	AddRoute("PATCH "+pattern, Chain(handles...))
+func PATCH(pattern string, handles ...Handle) *Route {
+	return defaultMux.PATCH(pattern, handles...)
 }
 
 // DELETE returns a Handle to handle DELETE requests
-func DELETE(pattern string, handles ...Handle) {
-	AddRoute("DELETE "+pattern, Chain(handles...))
+func DELETE(pattern string, handles ...Handle) *Route {
+	return defaultMux.DELETE(pattern, handles...)
+}
+
+// OPTIONS returns a Handle to handle OPTIONS requests
+func OPTIONS(pattern string, handles ...Handle) *Route {
+	return defaultMux.OPTIONS(pattern, handles...)
 }
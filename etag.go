@@ -0,0 +1,250 @@
+package rex
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETagOptions contains the options for the ETag middleware.
+type ETagOptions struct {
+	// Weak generates the weak ETags.
+	Weak bool
+}
+
+// ETag returns a middleware that generates the ETags of the buffered bodies of
+// the GET and HEAD requests, like the strings, bytes, JSON and the Render output.
+// The requests with the matched If-None-Match header are replied with 304.
+// The strong ETags of the compressed responses are tagged with the encoding,
+// like "<hash>-br", the If-None-Match header matches the ETags of all encodings.
+func ETag(opts ...ETagOptions) Handle {
+	var o ETagOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	return func(ctx *Context) any {
+		ctx.etag = &o
+		return next
+	}
+}
+
+// generateETag returns the ETag of the body.
+func generateETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + etag
+	}
+	return etag
+}
+
+// encodedETag tags the strong ETag with the content coding of the compressed
+// response, the weak ETags are returned as is.
+func encodedETag(etag string, encoding string) string {
+	if strings.HasPrefix(etag, "W/") || len(etag) < 2 || !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + encoding + `"`
+}
+
+// trimETagEncoding removes the content coding tagged by encodedETag.
+func trimETagEncoding(etag string) string {
+	for _, encoding := range []string{"br", "zstd", "gzip"} {
+		if strings.HasSuffix(etag, "-"+encoding+`"`) {
+			return etag[:len(etag)-len(encoding)-2] + `"`
+		}
+	}
+	return etag
+}
+
+// CheckPreconditions evaluates the conditional headers of the request against
+// the current ETag and modification time of the resource as defined in RFC 9110,
+// the empty etag and the zero modtime mean the resource doesn't exist. It returns
+// nil if the request should proceed, or a 304 or 412 response to reply, e.g.
+// the PUT requests with an outdated If-Match header are replied with 412.
+func (ctx *Context) CheckPreconditions(etag string, modtime time.Time) any {
+	switch evaluatePreconditions(ctx.R, etag, modtime) {
+	case 304:
+		if etag != "" {
+			ctx.header.Set("ETag", etag)
+		}
+		if !modtime.IsZero() {
+			ctx.header.Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+		}
+		return &status{304, nil}
+	case 412:
+		return &invalid{412, "Precondition Failed"}
+	}
+	return nil
+}
+
+// checkPreconditions generates the ETag of the buffered body if the ETag middleware
+// is used, and replies 304 or 412 if the preconditions of the GET or HEAD request
+// fail. The body is nil if it's not buffered, the encoding is the content coding
+// the response will be compressed with, the preconditions are evaluated with the
+// ETag tagged with it.
+func (ctx *Context) checkPreconditions(code int, body []byte, encoding string) bool {
+	r := ctx.R
+	if code != 200 || ctx.handlingError || (r.Method != "GET" && r.Method != "HEAD") {
+		return false
+	}
+	h := ctx.W.Header()
+	etag := h.Get("ETag")
+	if etag == "" && ctx.etag != nil && body != nil {
+		etag = generateETag(body, ctx.etag.Weak)
+		h.Set("ETag", etag)
+	}
+	var modtime time.Time
+	if lm := h.Get("Last-Modified"); lm != "" {
+		modtime, _ = http.ParseTime(lm)
+	}
+	if etag == "" && modtime.IsZero() {
+		return false
+	}
+	if etag != "" && encoding != "" {
+		etag = encodedETag(etag, encoding)
+	}
+	result := evaluatePreconditions(r, etag, modtime)
+	if result == 304 && etag != "" {
+		h.Set("ETag", etag)
+	}
+	return ctx.replyPrecondition(result)
+}
+
+// replyPrecondition replies 304 or 412, it returns false for other codes.
+func (ctx *Context) replyPrecondition(code int) bool {
+	switch code {
+	case 304:
+		h := ctx.W.Header()
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		h.Del("Content-Encoding")
+		ctx.W.WriteHeader(304)
+		return true
+	case 412:
+		ctx.respondWithError(&invalid{412, "Precondition Failed"})
+		return true
+	}
+	return false
+}
+
+// bufferedContent returns the bytes of the in-memory content.
+func bufferedContent(r io.Reader) ([]byte, bool) {
+	switch r := r.(type) {
+	case *bytes.Reader:
+		data := make([]byte, r.Len())
+		r.ReadAt(data, 0)
+		return data, true
+	case *strings.Reader:
+		data := make([]byte, r.Len())
+		r.ReadAt(data, 0)
+		return data, true
+	}
+	return nil, false
+}
+
+// evaluatePreconditions evaluates the conditional headers of the request in the
+// order defined in RFC 9110 section 13.2.2, it returns 304 or 412 if a
+// precondition fails, or 0 if the request should proceed. The If-Range header
+// is evaluated by the range requests.
+func evaluatePreconditions(r *http.Request, etag string, modtime time.Time) int {
+	if modtime.Equal(time.Unix(0, 0)) {
+		modtime = time.Time{}
+	}
+	// the Last-Modified header truncates sub-second precision
+	modtime = modtime.Truncate(time.Second)
+	exists := etag != "" || !modtime.IsZero()
+	isGetOrHead := r.Method == "GET" || r.Method == "HEAD"
+
+	if im := strings.Join(r.Header.Values("If-Match"), ","); im != "" {
+		if !matchETags(im, etag, exists, true) {
+			return 412
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !modtime.IsZero() {
+		if t, err := http.ParseTime(ius); err == nil && modtime.After(t) {
+			return 412
+		}
+	}
+
+	if inm := strings.Join(r.Header.Values("If-None-Match"), ","); inm != "" {
+		if matchETags(inm, etag, exists, false) {
+			if isGetOrHead {
+				return 304
+			}
+			return 412
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && isGetOrHead && !modtime.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil && !modtime.After(t) {
+			return 304
+		}
+	}
+	return 0
+}
+
+// matchETags reports whether the ETag list of the header matches the etag with
+// the strong or weak comparison, the "*" matches any existing representation.
+// The weak comparison ignores the content codings tagged by encodedETag, so a
+// cached representation of another encoding is still valid; the strong
+// comparison matches the exact representation only.
+func matchETags(header string, etag string, exists bool, strong bool) bool {
+	for _, tag := range parseETagList(header) {
+		if tag == "*" {
+			if exists {
+				return true
+			}
+			continue
+		}
+		if etag == "" {
+			continue
+		}
+		if strong {
+			if !strings.HasPrefix(tag, "W/") && !strings.HasPrefix(etag, "W/") && tag == etag {
+				return true
+			}
+		} else if trimETagEncoding(strings.TrimPrefix(tag, "W/")) == trimETagEncoding(strings.TrimPrefix(etag, "W/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseETagList parses the ETag list like `"a", W/"b"` or `*`, the invalid
+// items are skipped.
+func parseETagList(s string) []string {
+	var list []string
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return list
+		}
+		if s[0] == '*' {
+			list = append(list, "*")
+			s = s[1:]
+			continue
+		}
+		start := 0
+		if strings.HasPrefix(s, "W/") {
+			start = 2
+		}
+		if len(s) <= start || s[start] != '"' {
+			// skip the invalid item
+			i := strings.IndexByte(s, ',')
+			if i < 0 {
+				return list
+			}
+			s = s[i:]
+			continue
+		}
+		end := strings.IndexByte(s[start+1:], '"')
+		if end < 0 {
+			return list
+		}
+		end += start + 2
+		list = append(list, s[:end])
+		s = s[end:]
+	}
+}
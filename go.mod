@@ -5,6 +5,7 @@ go 1.25.0
 require (
 	github.com/andybalholm/brotli v1.2.2
 	github.com/ije/gox v0.10.4
+	github.com/klauspost/compress v1.18.0
 	github.com/rs/cors v1.11.1
 	golang.org/x/crypto v0.53.0
 )
@@ -0,0 +1,108 @@
+package rex
+
+import (
+	"strings"
+)
+
+// RouteGroup is a set of routes sharing a path prefix and middlewares.
+type RouteGroup struct {
+	mux         *Mux
+	parent      *RouteGroup
+	prefix      string
+	middlewares []Handle
+}
+
+// Group returns a new route group with the given prefix and middlewares.
+func (a *Mux) Group(prefix string, middlewares ...Handle) *RouteGroup {
+	g := &RouteGroup{mux: a, prefix: normalizePrefix(prefix)}
+	g.Use(middlewares...)
+	return g
+}
+
+// Group returns a nested route group with the given prefix and middlewares.
+func (g *RouteGroup) Group(prefix string, middlewares ...Handle) *RouteGroup {
+	sub := &RouteGroup{mux: g.mux, parent: g, prefix: g.prefix + normalizePrefix(prefix)}
+	sub.Use(middlewares...)
+	return sub
+}
+
+// Use appends middlewares to the group middleware stack.
+func (g *RouteGroup) Use(middlewares ...Handle) {
+	for _, handle := range middlewares {
+		if handle != nil {
+			g.middlewares = append(g.middlewares, handle)
+		}
+	}
+}
+
+// AddRoute adds a route to the group.
+func (g *RouteGroup) AddRoute(pattern string, handle Handle) *Route {
+	route := g.mux.AddRoute(g.pattern(pattern), func(ctx *Context) any {
+		return ctx.runHandles(append(g.handles(), handle))
+	})
+	route.Request, route.Response, _ = HandleTypes(handle)
+	return route
+}
+
+// HEAD returns a Handle to handle HEAD requests
+func (g *RouteGroup) HEAD(pattern string, handles ...Handle) *Route {
+	return g.AddRoute("HEAD "+pattern, Chain(handles...))
+}
+
+// GET returns a Handle to handle GET requests
+func (g *RouteGroup) GET(pattern string, handles ...Handle) *Route {
+	return g.AddRoute("GET "+pattern, Chain(handles...))
+}
+
+// POST returns a Handle to handle POST requests
+func (g *RouteGroup) POST(pattern string, handles ...Handle) *Route {
+	return g.AddRoute("POST "+pattern, Chain(handles...))
+}
+
+// PUT returns a Handle to handle PUT requests
+func (g *RouteGroup) PUT(pattern string, handles ...Handle) *Route {
+	return g.AddRoute("PUT "+pattern, Chain(handles...))
+}
+
+// PATCH returns a Handle to handle PATCH requests
+func (g *RouteGroup) PATCH(pattern string, handles ...Handle) *Route {
+	return g.AddRoute("PATCH "+pattern, Chain(handles...))
+}
+
+// DELETE returns a Handle to handle DELETE requests
+func (g *RouteGroup) DELETE(pattern string, handles ...Handle) *Route {
+	return g.AddRoute("DELETE "+pattern, Chain(handles...))
+}
+
+// OPTIONS returns a Handle to handle OPTIONS requests
+func (g *RouteGroup) OPTIONS(pattern string, handles ...Handle) *Route {
+	return g.AddRoute("OPTIONS "+pattern, Chain(handles...))
+}
+
+// handles returns the middlewares of the group and its parents.
+func (g *RouteGroup) handles() []Handle {
+	var handles []Handle
+	if g.parent != nil {
+		handles = g.parent.handles()
+	}
+	return append(handles, g.middlewares...)
+}
+
+// pattern inserts the group prefix into the path of the given pattern,
+// keeping the optional method and host parts.
+func (g *RouteGroup) pattern(pattern string) string {
+	method, host, path := parsePattern(pattern)
+	if method != "" {
+		method += " "
+	}
+	return method + host + g.prefix + path
+}
+
+// normalizePrefix ensures the prefix starts with a slash and has no trailing slash.
+func normalizePrefix(prefix string) string {
+	prefix = strings.TrimRight(prefix, "/")
+	if prefix != "" && prefix[0] != '/' {
+		prefix = "/" + prefix
+	}
+	return prefix
+}
@@ -0,0 +1,339 @@
+package rex
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	metricNameRegexp  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	metricLabelRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// DefaultBuckets are the default buckets of the request duration histogram in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultMetricsRegistry is the registry used by the Metrics middleware by default.
+var DefaultMetricsRegistry = NewMetricsRegistry()
+
+// MetricsRegistry is a set of metrics that can be rendered in the Prometheus
+// text exposition format.
+type MetricsRegistry struct {
+	lock    sync.Mutex
+	metrics []*metric
+	names   map[string]*metric
+}
+
+// NewMetricsRegistry returns a new MetricsRegistry.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{names: map[string]*metric{}}
+}
+
+// Counter returns the counter of the name, it's created if not exists.
+func (reg *MetricsRegistry) Counter(name string, help string, labelNames ...string) *Counter {
+	return &Counter{reg.register("counter", name, help, nil, labelNames)}
+}
+
+// Gauge returns the gauge of the name, it's created if not exists.
+func (reg *MetricsRegistry) Gauge(name string, help string, labelNames ...string) *Gauge {
+	return &Gauge{reg.register("gauge", name, help, nil, labelNames)}
+}
+
+// Histogram returns the histogram of the name, it's created if not exists.
+// The DefaultBuckets are used if the buckets is empty.
+func (reg *MetricsRegistry) Histogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{reg.register("histogram", name, help, buckets, labelNames)}
+}
+
+func (reg *MetricsRegistry) register(kind string, name string, help string, buckets []float64, labelNames []string) *metric {
+	if !metricNameRegexp.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, l := range labelNames {
+		if !metricLabelRegexp.MatchString(l) || l == "le" {
+			panic(fmt.Sprintf("metrics: invalid label name %q", l))
+		}
+	}
+
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	if m, ok := reg.names[name]; ok {
+		if m.kind != kind || strings.Join(m.labelNames, ",") != strings.Join(labelNames, ",") {
+			panic(fmt.Sprintf("metrics: %s %q is registered with different labels or type", m.kind, name))
+		}
+		return m
+	}
+	m := &metric{
+		kind:       kind,
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     map[string]*series{},
+	}
+	reg.metrics = append(reg.metrics, m)
+	reg.names[name] = m
+	return m
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (reg *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	reg.lock.Lock()
+	metrics := append([]*metric(nil), reg.metrics...)
+	reg.lock.Unlock()
+
+	buf := bytes.NewBuffer(nil)
+	for _, m := range metrics {
+		m.writeTo(buf)
+	}
+	return buf.WriteTo(w)
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (reg *MetricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	reg.WriteTo(w)
+}
+
+// Counter is a metric that only goes up.
+type Counter struct {
+	m *metric
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds the value to the counter, the value must not be negative.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter can not decrease")
+	}
+	c.m.update(labelValues, func(s *series) {
+		s.value += v
+	})
+}
+
+// Gauge is a metric that can go up and down.
+type Gauge struct {
+	m *metric
+}
+
+// Set sets the gauge to the value.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.update(labelValues, func(s *series) {
+		s.value = v
+	})
+}
+
+// Add adds the value to the gauge.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.m.update(labelValues, func(s *series) {
+		s.value += v
+	})
+}
+
+// Inc increments the gauge by 1.
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec decrements the gauge by 1.
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Histogram counts the observed values in buckets.
+type Histogram struct {
+	m *metric
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.m.update(labelValues, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.m.buckets))
+		}
+		for i, b := range h.m.buckets {
+			if v <= b {
+				s.counts[i]++
+			}
+		}
+		s.count++
+		s.value += v
+	})
+}
+
+type metric struct {
+	lock       sync.Mutex
+	kind       string
+	name       string
+	help       string
+	labelNames []string
+	buckets    []float64
+	series     map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64  // the value of counters and gauges, or the sum of histograms
+	count       uint64   // the count of histograms
+	counts      []uint64 // the cumulative counts of the histogram buckets
+}
+
+func (m *metric) update(labelValues []string, fn func(s *series)) {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metrics: %q expects %d label values, got %d", m.name, len(m.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		m.series[key] = s
+	}
+	fn(s)
+}
+
+func (m *metric) writeTo(buf *bytes.Buffer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.help != "" {
+		fmt.Fprintf(buf, "# HELP %s %s\n", m.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(m.help))
+	}
+	fmt.Fprintf(buf, "# TYPE %s %s\n", m.name, m.kind)
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != "histogram" {
+			fmt.Fprintf(buf, "%s%s %s\n", m.name, m.labels(s.labelValues, ""), formatMetricValue(s.value))
+			continue
+		}
+		for i, b := range m.buckets {
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", m.name, m.labels(s.labelValues, formatMetricValue(b)), s.counts[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", m.name, m.labels(s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", m.name, m.labels(s.labelValues, ""), formatMetricValue(s.value))
+		fmt.Fprintf(buf, "%s_count%s %d\n", m.name, m.labels(s.labelValues, ""), s.count)
+	}
+}
+
+// labels formats the labels of a series, the le label is added for the histogram buckets.
+func (m *metric) labels(values []string, le string) string {
+	if len(values) == 0 && le == "" {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(m.labelNames[i])
+		sb.WriteString(`="`)
+		sb.WriteString(escaper.Replace(v))
+		sb.WriteByte('"')
+	}
+	if le != "" {
+		if len(values) > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(`le="`)
+		sb.WriteString(le)
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// MetricsHandler returns a handle that replies the metrics of the registry in
+// the Prometheus text exposition format, the DefaultMetricsRegistry is used if
+// the registry is nil.
+func MetricsHandler(reg *MetricsRegistry) Handle {
+	if reg == nil {
+		reg = DefaultMetricsRegistry
+	}
+	return func(ctx *Context) any {
+		return reg
+	}
+}
+
+// Metrics returns a middleware that records the request metrics by the route
+// pattern into the registry, the DefaultMetricsRegistry is used if the registry
+// is not provided.
+func Metrics(registry ...*MetricsRegistry) Handle {
+	reg := DefaultMetricsRegistry
+	if len(registry) > 0 && registry[0] != nil {
+		reg = registry[0]
+	}
+	requests := reg.Counter("rex_http_requests_total", "Total number of HTTP requests.", "method", "route", "status")
+	duration := reg.Histogram("rex_http_request_duration_seconds", "Duration of HTTP requests in seconds.", DefaultBuckets, "method", "route")
+	size := reg.Histogram("rex_http_response_size_bytes", "Size of HTTP responses in bytes.", []float64{100, 1000, 10000, 100000, 1e6, 1e7}, "method", "route")
+	inFlight := reg.Gauge("rex_http_requests_in_flight", "Number of HTTP requests being served.")
+	return func(ctx *Context) any {
+		startTime := time.Now()
+		inFlight.Inc()
+		ctx.OnFinish(func(status int, written int64) {
+			inFlight.Dec()
+			method := metricsMethod(ctx.R.Method)
+			route := ctx.RoutePattern()
+			if route == "" {
+				route = "unmatched"
+			} else {
+				_, host, path := parsePattern(route)
+				route = host + path
+			}
+			requests.Inc(method, route, strconv.Itoa(status/100)+"xx")
+			duration.Observe(time.Since(startTime).Seconds(), method, route)
+			size.Observe(float64(written), method, route)
+		})
+		return next
+	}
+}
+
+// metricsMethod returns the method label of the request, the non-standard
+// methods are labeled as "other" to bound the number of the series.
+func metricsMethod(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "CONNECT", "OPTIONS", "TRACE":
+		return method
+	}
+	return "other"
+}
@@ -1,8 +1,10 @@
 package rex
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
+	"io/fs"
 	"net/http"
 	"strings"
 
@@ -36,12 +38,14 @@ func Logger(logger ILogger) Handle {
 	}
 }
 
-// AccessLogger returns a logger middleware to sets the access logger.
-func AccessLogger(logger ILogger) Handle {
-	return func(ctx *Context) any {
-		ctx.accessLogger = logger
-		return next
+// AccessLogger returns a logger middleware to sets the access logger,
+// the optional AccessLogOptions sets the format, sampling and exclusion rules.
+func AccessLogger(logger ILogger, opts ...AccessLogOptions) Handle {
+	var o AccessLogOptions
+	if len(opts) > 0 {
+		o = opts[0]
 	}
+	return newAccessLogHandle(logger, o)
 }
 
 // SessionOptions contains the options for the session manager.
@@ -173,6 +177,35 @@ func Cors(c CorsOptions) Handle {
 	}
 }
 
+// allowOrigin reports whether the origin is allowed by the options,
+// the origin functions take precedence over the AllowedOrigins.
+func (c *CorsOptions) allowOrigin(r *http.Request, origin string) bool {
+	switch {
+	case c.AllowOriginVaryRequestFunc != nil:
+		ok, _ := c.AllowOriginVaryRequestFunc(r, origin)
+		return ok
+	case c.AllowOriginRequestFunc != nil:
+		return c.AllowOriginRequestFunc(r, origin)
+	case c.AllowOriginFunc != nil:
+		return c.AllowOriginFunc(origin)
+	case len(c.AllowedOrigins) == 0:
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, o := range c.AllowedOrigins {
+		o = strings.ToLower(o)
+		if o == "*" || o == origin {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(o, "*"); ok {
+			if len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Perm returns a ACL middleware that sets the permission for the current request.
 func Perm(perms ...string) Handle {
 	permSet := make(map[string]struct{}, len(perms))
@@ -235,7 +268,24 @@ func AclAuth(auth func(ctx *Context) AclUser) Handle {
 // Compress returns a rex middleware to enable http compression.
 func Compress() Handle {
 	return func(ctx *Context) any {
-		ctx.compress = true
+		ctx.compress = defaultCompressor
+		return next
+	}
+}
+
+// CompressWithOptions returns a rex middleware to enable http compression with the options.
+func CompressWithOptions(opts CompressOptions) Handle {
+	c := newCompressor(opts)
+	return func(ctx *Context) any {
+		ctx.compress = c
+		return next
+	}
+}
+
+// MaxBodySize returns a middleware to limit the request body size that is read by the Bind method.
+func MaxBodySize(n int64) Handle {
+	return func(ctx *Context) any {
+		ctx.maxBodySize = n
 		return next
 	}
 }
@@ -247,6 +297,15 @@ func Static(root, fallback string) Handle {
 	}
 }
 
+// StaticFS returns a static file server middleware that serves the fsys,
+// e.g. an embed.FS.
+func StaticFS(fsys fs.FS, opts ...FSOptions) Handle {
+	s := FSFrom(fsys, opts...)
+	return func(ctx *Context) any {
+		return s
+	}
+}
+
 // Optional returns a middleware handler that executes the given handler only if the condition is true.
 func Optional(handle Handle, condition bool) Handle {
 	if condition {
@@ -263,13 +322,144 @@ func Chain(middlewares ...Handle) Handle {
 	if len(middlewares) == 0 {
 		panic("no middlewares in the chain")
 	}
+	return chain(middlewares).serve
+}
+
+// chain is the handle created by Chain.
+type chain []Handle
+
+// serve runs the handles, or reports the types of the last typed handle if the
+// context is a probe.
+func (c chain) serve(ctx *Context) any {
+	if ctx.probe != nil {
+		for i := len(c) - 1; i >= 0; i-- {
+			if in, out, ok := HandleTypes(c[i]); ok {
+				ctx.probe.in, ctx.probe.out = in, out
+				break
+			}
+		}
+		return nil
+	}
+	return ctx.runHandles(c)
+}
+
+// Around returns a middleware that wraps the rest of the handles, the next
+// func runs the rest handles and returns the result, which can be inspected
+// or transformed before it's replied. The request is replied with 404 if none
+// of the rest handles replies it.
+func Around(fn func(ctx *Context, next func() any) any) Handle {
 	return func(ctx *Context) any {
-		for _, mw := range middlewares {
-			v := mw(ctx)
-			if v != next {
-				return v
+		resume := ctx.rest.resume(ctx)
+		var result any
+		called := false
+		v := fn(ctx, func() any {
+			if !called {
+				called = true
+				result = next
+				if resume != nil {
+					result = resume()
+				}
+			}
+			return result
+		})
+		if v == next && called {
+			// the rest handles have been executed, don't run them again
+			v = result
+			if v == next {
+				// none of the rest handles replied the request
+				return &invalid{404, "Not Found"}
 			}
 		}
-		return next
+		return v
 	}
 }
+
+type wrapKey struct{}
+
+// wrapState is passed to the next handler of the wrapped middleware by the request context.
+type wrapState struct {
+	ctx  *Context
+	next func() any
+}
+
+// Wrap returns a middleware that runs a standard net/http middleware in the chain,
+// the request and the response writer passed to the next handler are used by
+// the rest handles. The next handler must be called synchronously.
+func Wrap(mw func(http.Handler) http.Handler) Handle {
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state, ok := r.Context().Value(wrapKey{}).(*wrapState)
+		if !ok {
+			http.Error(w, "rex: the wrapped handler is called outside of the middleware", 500)
+			return
+		}
+		ctx := state.ctx
+		ctx.R = r
+		ctx.W = w
+		ctx.header = w.Header()
+		// reply the result by the response writer of the middleware
+		if v := state.next(); v != nil {
+			ctx.respondWith(v)
+		}
+	}))
+	return Around(func(ctx *Context, next func() any) any {
+		w, header := ctx.W, ctx.header
+		defer func() {
+			ctx.W = w
+			ctx.header = header
+		}()
+		state := &wrapState{ctx, next}
+		h.ServeHTTP(w, ctx.R.WithContext(context.WithValue(ctx.R.Context(), wrapKey{}, state)))
+		return nil
+	})
+}
+
+// continuation is the rest of the handles after the current handle, the then
+// func continues the caller of the handles.
+type continuation struct {
+	handles []Handle
+	then    func(ctx *Context) any
+}
+
+// resume returns a func that runs the continuation on the ctx, or nil if there
+// is nothing to run.
+func (c continuation) resume(ctx *Context) func() any {
+	if len(c.handles) == 0 {
+		if c.then == nil {
+			return nil
+		}
+		return func() any {
+			return c.then(ctx)
+		}
+	}
+	return func() any {
+		ctx.rest = continuation{then: c.then}
+		v := ctx.runHandles(c.handles)
+		if v == next && c.then != nil {
+			return c.then(ctx)
+		}
+		return v
+	}
+}
+
+// runHandles executes the handles in order until one of them returns a value
+// other than next.
+func (ctx *Context) runHandles(handles []Handle) any {
+	saved := ctx.rest
+	defer func() {
+		ctx.rest = saved
+	}()
+	then := saved.then
+	if len(saved.handles) > 0 {
+		then = func(ctx *Context) any {
+			return saved.resume(ctx)()
+		}
+	}
+	for i, handle := range handles {
+		ctx.rest = continuation{handles[i+1:], then}
+		v := handle(ctx)
+		if v != next {
+			return v
+		}
+	}
+	return next
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"runtime"
 	"strings"
 	"sync"
@@ -15,10 +16,15 @@ type Handle func(ctx *Context) any
 
 // Mux is a http.Handler with middlewares and routes.
 type Mux struct {
-	contextPool sync.Pool
-	writerPool  sync.Pool
-	middlewares []Handle
-	router      *http.ServeMux
+	contextPool      sync.Pool
+	writerPool       sync.Pool
+	middlewares      []Handle
+	router           *http.ServeMux
+	routes           []*Route
+	notFound         Handle
+	methodNotAllowed Handle
+	errorHandler     func(ctx *Context, err error) any
+	trustedProxies   []netip.Prefix
 }
 
 // New returns a new Mux.
@@ -46,8 +52,27 @@ func (a *Mux) Use(middlewares ...Handle) {
 	}
 }
 
+// NotFound sets the handle to reply the requests that match no routes.
+func (a *Mux) NotFound(handle Handle) {
+	a.notFound = handle
+}
+
+// MethodNotAllowed sets the handle to reply the requests whose path matches
+// a route but the method doesn't, the Allow header is set before the handle is called.
+func (a *Mux) MethodNotAllowed(handle Handle) {
+	a.methodNotAllowed = handle
+}
+
+// ErrorHandler sets the handler to reply the errors, including the errors returned
+// by the handles, the invalid values created by Invalid and the recovered panics.
+// The returned value is replied to the client, [ProblemDetails] can be used
+// as the handler to reply the errors in the problem details format.
+func (a *Mux) ErrorHandler(handler func(ctx *Context, err error) any) {
+	a.errorHandler = handler
+}
+
 // AddRoute adds a route.
-func (a *Mux) AddRoute(pattern string, handle Handle) {
+func (a *Mux) AddRoute(pattern string, handle Handle) *Route {
 	// create the router on demand
 	if a.router == nil {
 		a.router = http.NewServeMux()
@@ -55,12 +80,54 @@ func (a *Mux) AddRoute(pattern string, handle Handle) {
 	a.router.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
 		wr, ok := w.(*rexWriter)
 		if ok {
-			v := handle(wr.ctx)
-			if v != nil {
-				wr.ctx.respondWith(v)
-			}
+			// the result is replied by the ServeHTTP after the around middlewares
+			wr.ctx.result = handle(wr.ctx)
 		}
 	})
+	route := newRoute(pattern)
+	route.Request, route.Response, _ = HandleTypes(handle)
+	a.routes = append(a.routes, route)
+	return route
+}
+
+// Routes returns the registered routes.
+func (a *Mux) Routes() []*Route {
+	return append([]*Route(nil), a.routes...)
+}
+
+// HEAD returns a Handle to handle HEAD requests
+func (a *Mux) HEAD(pattern string, handles ...Handle) *Route {
+	return a.AddRoute("HEAD "+pattern, Chain(handles...))
+}
+
+// GET returns a Handle to handle GET requests
+func (a *Mux) GET(pattern string, handles ...Handle) *Route {
+	return a.AddRoute("GET "+pattern, Chain(handles...))
+}
+
+// POST returns a Handle to handle POST requests
+func (a *Mux) POST(pattern string, handles ...Handle) *Route {
+	return a.AddRoute("POST "+pattern, Chain(handles...))
+}
+
+// PUT returns a Handle to handle PUT requests
+func (a *Mux) PUT(pattern string, handles ...Handle) *Route {
+	return a.AddRoute("PUT "+pattern, Chain(handles...))
+}
+
+// PATCH returns a Handle to handle PATCH requests
+func (a *Mux) PATCH(pattern string, handles ...Handle) *Route {
+	return a.AddRoute("PATCH "+pattern, Chain(handles...))
+}
+
+// DELETE returns a Handle to handle DELETE requests
+func (a *Mux) DELETE(pattern string, handles ...Handle) *Route {
+	return a.AddRoute("DELETE "+pattern, Chain(handles...))
+}
+
+// OPTIONS returns a Handle to handle OPTIONS requests
+func (a *Mux) OPTIONS(pattern string, handles ...Handle) *Route {
+	return a.AddRoute("OPTIONS "+pattern, Chain(handles...))
 }
 
 // ServeHTTP implements the http Handler.
@@ -70,82 +137,130 @@ func (a *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	wr := a.newWriter(ctx, w)
 	defer a.recycleWriter(wr)
+	defer func() {
+		for _, fn := range ctx.onFinish {
+			fn(wr.code, int64(wr.writeN))
+		}
+	}()
 	defer wr.Close()
 
 	ctx.W = wr
+	ctx.writer = wr
 	ctx.header = w.Header()
 	ctx.header.Set("Connection", "keep-alive")
 
-	if r.Method != "OPTIONS" {
-		startTime := time.Now()
-		defer func() {
-			if ctx.accessLogger != nil {
-				ref := r.Referer()
-				if ref == "" {
-					ref = "-"
-				}
-				ctx.accessLogger.Printf(
-					`%s %s %s %s %s %d %s "%s" %d %d %dms`,
-					ctx.RemoteIP(),
-					r.Host,
-					r.Proto,
-					r.Method,
-					r.RequestURI,
-					r.ContentLength,
-					ref,
-					strings.ReplaceAll(r.UserAgent(), `"`, `\"`),
-					wr.code,
-					wr.writeN,
-					time.Since(startTime)/time.Millisecond,
-				)
-			}
-		}()
-	}
+	startTime := time.Now()
+	defer func() {
+		if ctx.accessLog != nil {
+			ctx.accessLog.log(ctx, wr.code, wr.writeN, startTime)
+		}
+	}()
 
 	defer func() {
 		if v := recover(); v != nil {
-			if err, ok := v.(*invalid); ok {
-				ctx.W.WriteHeader(err.code)
-				ctx.W.Write([]byte(err.message))
-				return
-			}
-
-			buf := bytes.NewBuffer(nil)
-			for i := 3; ; i++ {
-				pc, file, line, ok := runtime.Caller(i)
-				if !ok {
-					break
-				}
-				fmt.Fprint(buf, "\t", strings.TrimSpace(runtime.FuncForPC(pc).Name()), " ", file, ":", line, "\n")
-			}
-
-			if ctx.logger != nil {
-				ctx.logger.Printf("[panic] %v\n%s", v, buf.String())
-			}
-			ctx.W.WriteHeader(500)
-			ctx.W.Write([]byte("Internal Server Error"))
+			ctx.recoverPanic(v)
 		}
 	}()
 
-	for _, handle := range a.middlewares {
-		v := handle(ctx)
-		if v != next {
-			ctx.respondWith(v)
-			return
+	ctx.rest = continuation{then: a.dispatch}
+	v := ctx.runHandles(a.middlewares)
+	if v == next {
+		v = a.dispatch(ctx)
+	}
+	if v != nil {
+		ctx.respondWith(v)
+	}
+}
+
+// recoverPanic replies the recovered panic, the stack is logged unless the
+// panic value is an invalid or an Error.
+func (ctx *Context) recoverPanic(v any) {
+	switch err := v.(type) {
+	case *invalid:
+		ctx.respondWithError(err)
+		return
+	case *Error:
+		ctx.respondWithError(err)
+		return
+	}
+
+	buf := bytes.NewBuffer(nil)
+	for i := 4; ; i++ {
+		pc, file, line, ok := runtime.Caller(i)
+		if !ok {
+			break
 		}
+		fmt.Fprint(buf, "\t", strings.TrimSpace(runtime.FuncForPC(pc).Name()), " ", file, ":", line, "\n")
 	}
 
+	ctx.logf("panic", "%v\n%s", v, buf.String())
+	ctx.respondWithError(&panicError{v})
+}
+
+// dispatch routes the request and returns the result of the matched route.
+func (a *Mux) dispatch(ctx *Context) any {
+	// the routes start a new continuation
+	ctx.rest = continuation{}
+	r := ctx.R
+
 	if a.router != nil {
-		a.router.ServeHTTP(wr, r)
-		return
+		if _, pattern := a.router.Handler(r); pattern != "" {
+			a.router.ServeHTTP(ctx.writer, r)
+			v := ctx.result
+			ctx.result = nil
+			return v
+		}
 	}
 
-	if r.Method == "GET" {
-		ctx.respondWith(&status{404, "Not Found"})
-		return
+	allow := a.allowedMethods(r)
+	if len(allow) == 0 {
+		if a.notFound != nil {
+			return a.notFound(ctx)
+		}
+		return &invalid{404, "Not Found"}
 	}
 
-	ctx.respondWith(&status{405, "Method Not Allowed"})
+	ctx.header.Set("Allow", strings.Join(allow, ", "))
+	if r.Method == "OPTIONS" {
+		return &noContent{}
+	}
+	if a.methodNotAllowed != nil {
+		return a.methodNotAllowed(ctx)
+	}
+	return &invalid{405, "Method Not Allowed"}
+}
+
+// allowedMethods returns the methods of the routes that match the request path.
+func (a *Mux) allowedMethods(r *http.Request) []string {
+	if a.router == nil {
+		return nil
+	}
+	var methods []string
+	allowed := map[string]bool{}
+	seen := map[string]bool{}
+	for _, route := range a.routes {
+		method := route.Method
+		if method == "" || method == r.Method || seen[method] {
+			continue
+		}
+		seen[method] = true
+		probe := *r
+		probe.Method = method
+		if _, pattern := a.router.Handler(&probe); pattern != "" {
+			allowed[method] = true
+			methods = append(methods, method)
+		}
+	}
+	if len(methods) > 0 {
+		// the GET routes match the HEAD requests as well
+		if allowed["GET"] && !allowed["HEAD"] {
+			methods = append(methods, "HEAD")
+		}
+		if !allowed["OPTIONS"] {
+			methods = append(methods, "OPTIONS")
+		}
+	}
+	return methods
 }
 
 // newContext returns a new Context from the pool.
@@ -155,6 +270,7 @@ func (a *Mux) newContext(r *http.Request) (ctx *Context) {
 	ctx.sessionPool = defaultSessionPool
 	ctx.sessionIdHandler = defaultSessionIdHandler
 	ctx.logger = defaultLogger
+	ctx.mux = a
 	return
 }
 
@@ -162,6 +278,7 @@ func (a *Mux) newContext(r *http.Request) (ctx *Context) {
 func (a *Mux) recycleContext(ctx *Context) {
 	ctx.R = nil
 	ctx.W = nil
+	ctx.writer = nil
 	ctx.header = nil
 	ctx.basicAuthUser = ""
 	ctx.aclUser = nil
@@ -169,8 +286,18 @@ func (a *Mux) recycleContext(ctx *Context) {
 	ctx.sessionPool = nil
 	ctx.sessionIdHandler = nil
 	ctx.logger = nil
-	ctx.accessLogger = nil
-	ctx.compress = false
+	ctx.accessLog = nil
+	ctx.compress = nil
+	ctx.maxBodySize = 0
+	ctx.mux = nil
+	ctx.handlingError = false
+	ctx.forwarded = nil
+	ctx.rest = continuation{}
+	ctx.result = nil
+	ctx.onFinish = nil
+	ctx.requestID = ""
+	ctx.etag = nil
+	ctx.span = nil
 	a.contextPool.Put(ctx)
 }
 
@@ -191,5 +318,7 @@ func (a *Mux) recycleWriter(wr *rexWriter) {
 	wr.writeN = 0
 	wr.rawWriter = nil
 	wr.zWriter = nil
+	wr.zPool = nil
+	wr.hijacked = false
 	a.writerPool.Put(wr)
 }
@@ -0,0 +1,85 @@
+package rex
+
+import (
+	"strconv"
+	"strings"
+)
+
+// qualityValue is an item of a header value with quality values, e.g. "text/html;q=0.8".
+type qualityValue struct {
+	value string
+	q     float64
+}
+
+// parseQualityList parses the header value with quality values like Accept
+// and Accept-Encoding, the items are returned in the original order.
+func parseQualityList(header string) []qualityValue {
+	var list []qualityValue
+	for _, item := range strings.Split(header, ",") {
+		value, params, _ := strings.Cut(item, ";")
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				}
+			}
+		}
+		list = append(list, qualityValue{value, q})
+	}
+	return list
+}
+
+// negotiate returns the best offered media type for the Accept header. The
+// first offer is returned if the header is empty or no offers are acceptable.
+func negotiate(accept string, offers ...string) string {
+	if accept == "" {
+		return offers[0]
+	}
+	ranges := parseQualityList(accept)
+	best, bestQ := offers[0], 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			var s int
+			switch {
+			case r.value == offer:
+				s = 2
+			case r.value == "*/*":
+				s = 0
+			case strings.HasSuffix(r.value, "/*") && strings.HasPrefix(offer, r.value[:len(r.value)-1]):
+				s = 1
+			default:
+				continue
+			}
+			// the most specific range wins
+			if s > specificity {
+				q, specificity = r.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// encodingQuality returns the quality value of the content coding in the
+// parsed Accept-Encoding header, the "*" matches any coding.
+func encodingQuality(list []qualityValue, encoding string) float64 {
+	star := 0.0
+	for _, v := range list {
+		if v.value == encoding {
+			return v.q
+		}
+		if v.value == "*" {
+			star = v.q
+		}
+	}
+	return star
+}
@@ -0,0 +1,652 @@
+package rex
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAPIInfo contains the metadata of the OpenAPI document.
+type OpenAPIInfo struct {
+	Title       string
+	Version     string
+	Description string
+	Servers     []string
+}
+
+// OpenAPIDocument is an OpenAPI 3.1 document.
+type OpenAPIDocument struct {
+	root object
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (doc *OpenAPIDocument) MarshalJSON() ([]byte, error) {
+	return doc.root.MarshalJSON()
+}
+
+// JSON returns the indented JSON encoding of the document.
+func (doc *OpenAPIDocument) JSON() ([]byte, error) {
+	return json.MarshalIndent(doc.root, "", "  ")
+}
+
+// YAML returns the YAML encoding of the document.
+func (doc *OpenAPIDocument) YAML() []byte {
+	buf := bytes.NewBuffer(nil)
+	writeYAMLMembers(buf, doc.root, 0)
+	return buf.Bytes()
+}
+
+// OpenAPI generates the OpenAPI document from the registered routes.
+// Routes without a method in the pattern or marked as hidden are excluded.
+func (a *Mux) OpenAPI(info OpenAPIInfo) *OpenAPIDocument {
+	g := &schemaGenerator{names: map[reflect.Type]string{}}
+	paths := map[string]*object{}
+	for _, route := range a.routes {
+		if route.Hidden || route.Method == "" {
+			continue
+		}
+		path := openAPIPath(route.Path)
+		item, ok := paths[path]
+		if !ok {
+			item = &object{}
+			paths[path] = item
+		}
+		item.set(strings.ToLower(route.Method), g.operation(route, path))
+	}
+	pathKeys := make([]string, 0, len(paths))
+	for path := range paths {
+		pathKeys = append(pathKeys, path)
+	}
+	sort.Strings(pathKeys)
+
+	infoObj := object{{"title", info.Title}, {"version", info.Version}}
+	if infoObj[0].value == "" {
+		infoObj[0].value = "API"
+	}
+	if infoObj[1].value == "" {
+		infoObj[1].value = "0.0.0"
+	}
+	if info.Description != "" {
+		infoObj.set("description", info.Description)
+	}
+	root := object{{"openapi", "3.1.0"}, {"info", infoObj}}
+	if len(info.Servers) > 0 {
+		servers := make([]any, len(info.Servers))
+		for i, url := range info.Servers {
+			servers[i] = object{{"url", url}}
+		}
+		root.set("servers", servers)
+	}
+	pathsObj := object{}
+	for _, path := range pathKeys {
+		pathsObj.set(path, *paths[path])
+	}
+	root.set("paths", pathsObj)
+	if len(g.schemas) > 0 {
+		root.set("components", object{{"schemas", g.schemas}})
+	}
+	return &OpenAPIDocument{root}
+}
+
+// OpenAPIHandler returns a Handle that replies with the OpenAPI document of the mux,
+// the document is encoded in YAML if the request path ends with ".yaml" or ".yml".
+func (a *Mux) OpenAPIHandler(info OpenAPIInfo) Handle {
+	return func(ctx *Context) any {
+		doc := a.OpenAPI(info)
+		if p := ctx.Pathname(); strings.HasSuffix(p, ".yaml") || strings.HasSuffix(p, ".yml") {
+			ctx.header.Set("Content-Type", "application/yaml; charset=utf-8")
+			return doc.YAML()
+		}
+		data, err := doc.JSON()
+		if err != nil {
+			return err
+		}
+		ctx.header.Set("Content-Type", "application/json; charset=utf-8")
+		return data
+	}
+}
+
+// OpenAPIViewer returns a Handle that replies with a HTML page to view
+// the OpenAPI document at the specURL.
+func OpenAPIViewer(specURL string) Handle {
+	url, _ := json.Marshal(specURL)
+	html := strings.Replace(openAPIViewerHTML, "{{SPEC_URL}}", string(url), 1)
+	return func(ctx *Context) any {
+		return HTML(html)
+	}
+}
+
+// operation creates the operation object of the route.
+func (g *schemaGenerator) operation(route *Route, path string) object {
+	op := object{}
+	if len(route.Tags) > 0 {
+		tags := make([]any, len(route.Tags))
+		for i, tag := range route.Tags {
+			tags[i] = tag
+		}
+		op.set("tags", tags)
+	}
+	if route.Summary != "" {
+		op.set("summary", route.Summary)
+	}
+	if route.Description != "" {
+		op.set("description", route.Description)
+	}
+	op.set("operationId", operationID(route.Method, path))
+
+	in := route.Request
+	for in != nil && in.Kind() == reflect.Pointer {
+		in = in.Elem()
+	}
+	isStruct := in != nil && in.Kind() == reflect.Struct
+
+	params := []any{}
+	for _, name := range route.Params {
+		var schema any = object{{"type", "string"}}
+		if isStruct {
+			if sf, ok := findTaggedField(in, "path", name); ok {
+				schema = g.fieldSchema(sf)
+			}
+		}
+		params = append(params, object{{"name", name}, {"in", "path"}, {"required", true}, {"schema", schema}})
+	}
+	if isStruct {
+		for _, source := range []string{"query", "header"} {
+			walkFields(in, func(sf reflect.StructField) {
+				name := sf.Tag.Get(source)
+				if name == "" || name == "-" {
+					return
+				}
+				param := object{{"name", name}, {"in", source}}
+				if hasRequiredRule(sf) {
+					param.set("required", true)
+				}
+				param.set("schema", g.fieldSchema(sf))
+				params = append(params, param)
+			})
+		}
+	}
+	if len(params) > 0 {
+		op.set("parameters", params)
+	}
+
+	switch route.Method {
+	case "GET", "HEAD", "DELETE", "OPTIONS":
+	default:
+		if in != nil {
+			var schema object
+			if isStruct {
+				schema = g.structSchema(in, true)
+			} else {
+				schema = g.schema(in)
+			}
+			if props, ok := schema.get("properties").(object); !isStruct || (ok && len(props) > 0) {
+				op.set("requestBody", object{
+					{"required", true},
+					{"content", object{{"application/json", object{{"schema", schema}}}}},
+				})
+			}
+		}
+	}
+
+	ok := object{{"description", "OK"}}
+	if route.Response != nil {
+		ok.set("content", object{{"application/json", object{{"schema", g.schema(route.Response)}}}})
+	}
+	responses := object{{"200", ok}}
+	if route.Request != nil || route.Response != nil {
+		errSchema := g.schema(reflect.TypeFor[Error]())
+		responses.set("default", object{
+			{"description", "Error"},
+			{"content", object{{"application/json", object{{"schema", errSchema}}}}},
+		})
+	}
+	op.set("responses", responses)
+	if route.Deprecated {
+		op.set("deprecated", true)
+	}
+	return op
+}
+
+// schemaGenerator generates the JSON schemas of the go types, named struct
+// types are put in the components.
+type schemaGenerator struct {
+	schemas object
+	names   map[reflect.Type]string
+}
+
+var (
+	timeType          = reflect.TypeFor[time.Time]()
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// schema returns the JSON schema of the type.
+func (g *schemaGenerator) schema(t reflect.Type) object {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return object{{"type", "string"}, {"format", "date-time"}}
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		return object{}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return object{{"type", "string"}}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return object{{"type", "boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16:
+		return object{{"type", "integer"}}
+	case reflect.Int32:
+		return object{{"type", "integer"}, {"format", "int32"}}
+	case reflect.Int64:
+		return object{{"type", "integer"}, {"format", "int64"}}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return object{{"type", "integer"}, {"minimum", 0}}
+	case reflect.Float32, reflect.Float64:
+		return object{{"type", "number"}}
+	case reflect.String:
+		return object{{"type", "string"}}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return object{{"type", "string"}, {"contentEncoding", "base64"}}
+		}
+		return object{{"type", "array"}, {"items", g.schema(t.Elem())}}
+	case reflect.Map:
+		return object{{"type", "object"}, {"additionalProperties", g.schema(t.Elem())}}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t, false)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = g.componentName(t)
+			g.names[t] = name
+			g.schemas.set(name, object{}) // placeholder for recursive types
+			g.schemas.set(name, g.structSchema(t, false))
+		}
+		return object{{"$ref", "#/components/schemas/" + name}}
+	default:
+		return object{}
+	}
+}
+
+// structSchema returns the object schema of the struct type, the fields
+// bound from the path, query or headers are excluded if bodyOnly is true.
+func (g *schemaGenerator) structSchema(t reflect.Type, bodyOnly bool) object {
+	props := object{}
+	required := []any{}
+	walkFields(t, func(sf reflect.StructField) {
+		if bodyOnly && (sf.Tag.Get("path") != "" || sf.Tag.Get("query") != "" || sf.Tag.Get("header") != "") {
+			return
+		}
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "" {
+			name = sf.Name
+		}
+		props.set(name, g.fieldSchema(sf))
+		if hasRequiredRule(sf) {
+			required = append(required, name)
+		}
+	})
+	schema := object{{"type", "object"}, {"properties", props}}
+	if len(required) > 0 {
+		schema.set("required", required)
+	}
+	return schema
+}
+
+// fieldSchema returns the schema of the struct field with the constraints
+// of the `validate` tag.
+func (g *schemaGenerator) fieldSchema(sf reflect.StructField) object {
+	schema := g.schema(sf.Type)
+	t := sf.Type
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	for _, r := range parseRules(sf.Tag.Get("validate")) {
+		switch r.name {
+		case "min", "max":
+			var key string
+			switch t.Kind() {
+			case reflect.String:
+				key = "Length"
+			case reflect.Slice, reflect.Array:
+				key = "Items"
+			case reflect.Map:
+				key = "Properties"
+			default:
+				if r.name == "min" {
+					schema.set("minimum", r.num)
+				} else {
+					schema.set("maximum", r.num)
+				}
+				continue
+			}
+			schema.set(r.name+key, int(r.num))
+		case "email":
+			schema.set("format", "email")
+		case "oneof":
+			enum := make([]any, len(r.options))
+			for i, option := range r.options {
+				enum[i] = option
+				if t.Kind() != reflect.String {
+					if n, err := strconv.ParseFloat(option, 64); err == nil {
+						enum[i] = n
+					}
+				}
+			}
+			schema.set("enum", enum)
+		case "regexp":
+			schema.set("pattern", r.arg)
+		}
+	}
+	return schema
+}
+
+// componentName returns a unique component name of the named type.
+func (g *schemaGenerator) componentName(t reflect.Type) string {
+	name := componentNameRegexp.ReplaceAllString(t.Name(), "_")
+	for other, n := range g.names {
+		if n == name && other != t {
+			name = componentNameRegexp.ReplaceAllString(t.String(), "_")
+			break
+		}
+	}
+	return name
+}
+
+var componentNameRegexp = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// walkFields calls fn for each JSON encoded field of the struct type,
+// the fields of embedded structs are flattened.
+func walkFields(t reflect.Type, fn func(sf reflect.StructField)) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				walkFields(ft, fn)
+				continue
+			}
+		}
+		if sf.IsExported() {
+			fn(sf)
+		}
+	}
+}
+
+// findTaggedField finds the field with the given tag value.
+func findTaggedField(t reflect.Type, tag string, value string) (field reflect.StructField, ok bool) {
+	walkFields(t, func(sf reflect.StructField) {
+		if !ok && sf.Tag.Get(tag) == value {
+			field, ok = sf, true
+		}
+	})
+	return
+}
+
+// hasRequiredRule checks if the field has the `required` validate rule.
+func hasRequiredRule(sf reflect.StructField) bool {
+	rules := parseRules(sf.Tag.Get("validate"))
+	return len(rules) > 0 && rules[0].name == "required"
+}
+
+// openAPIPath converts the ServeMux path pattern to the OpenAPI path.
+func openAPIPath(path string) string {
+	path = strings.ReplaceAll(path, "{$}", "")
+	return strings.ReplaceAll(path, "...}", "}")
+}
+
+// operationID generates the operation ID from the method and path, e.g. "getPostsById".
+func operationID(method string, path string) string {
+	buf := strings.Builder{}
+	buf.WriteString(strings.ToLower(method))
+	for _, seg := range strings.Split(path, "/") {
+		if seg == "" {
+			continue
+		}
+		if strings.HasPrefix(seg, "{") {
+			buf.WriteString("By")
+			seg = strings.Trim(seg, "{}")
+		}
+		for _, word := range strings.FieldsFunc(seg, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+		}) {
+			buf.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return buf.String()
+}
+
+// object is a JSON object that keeps the order of the keys.
+type object []member
+
+type member struct {
+	key   string
+	value any
+}
+
+// get returns the value of the key.
+func (o object) get(key string) any {
+	for _, m := range o {
+		if m.key == key {
+			return m.value
+		}
+	}
+	return nil
+}
+
+// set sets the value of the key, new keys are appended to the end.
+func (o *object) set(key string, value any) {
+	for i, m := range *o {
+		if m.key == key {
+			(*o)[i].value = value
+			return
+		}
+	}
+	*o = append(*o, member{key, value})
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (o object) MarshalJSON() ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(m.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// writeYAMLMembers writes the object members as a YAML block mapping.
+func writeYAMLMembers(buf *bytes.Buffer, o object, indent int) {
+	pad := strings.Repeat("  ", indent)
+	for _, m := range o {
+		buf.WriteString(pad)
+		buf.WriteString(yamlString(m.key))
+		buf.WriteByte(':')
+		writeYAMLValue(buf, m.value, indent)
+	}
+}
+
+// writeYAMLValue writes the value after the key of a mapping.
+func writeYAMLValue(buf *bytes.Buffer, v any, indent int) {
+	switch v := v.(type) {
+	case object:
+		if len(v) == 0 {
+			buf.WriteString(" {}\n")
+			return
+		}
+		buf.WriteByte('\n')
+		writeYAMLMembers(buf, v, indent+1)
+	case []any:
+		if len(v) == 0 {
+			buf.WriteString(" []\n")
+			return
+		}
+		buf.WriteByte('\n')
+		pad := strings.Repeat("  ", indent+1)
+		for _, item := range v {
+			if o, ok := item.(object); ok && len(o) > 0 {
+				// write the first member after the dash
+				block := bytes.NewBuffer(nil)
+				writeYAMLMembers(block, o, indent+2)
+				buf.WriteString(pad)
+				buf.WriteString("- ")
+				buf.Write(block.Bytes()[len(pad)+2:])
+				continue
+			}
+			buf.WriteString(pad)
+			buf.WriteByte('-')
+			writeYAMLValue(buf, item, indent+1)
+		}
+	case string:
+		buf.WriteByte(' ')
+		buf.WriteString(yamlString(v))
+		buf.WriteByte('\n')
+	case nil:
+		buf.WriteString(" null\n")
+	default:
+		data, _ := json.Marshal(v)
+		buf.WriteByte(' ')
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+}
+
+var yamlPlainRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_ ./$-]*$`)
+
+// yamlString returns the string as a plain YAML scalar if possible,
+// otherwise returns a double-quoted scalar.
+func yamlString(s string) string {
+	if yamlPlainRegexp.MatchString(s) && !strings.HasSuffix(s, " ") {
+		switch strings.ToLower(s) {
+		case "true", "false", "null", "yes", "no", "on", "off", "y", "n":
+		default:
+			return s
+		}
+	}
+	return strconv.Quote(s)
+}
+
+const openAPIViewerHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>API Reference</title>
+<style>
+body{font:14px/1.5 -apple-system,BlinkMacSystemFont,"Segoe UI",Helvetica,Arial,sans-serif;margin:0;color:#1f2328;background:#f6f8fa}
+main{max-width:960px;margin:0 auto;padding:24px}
+h1{margin:0 0 4px}
+.desc{color:#59636e;white-space:pre-wrap}
+details{background:#fff;border:1px solid #d1d9e0;border-radius:6px;margin:8px 0}
+summary{cursor:pointer;padding:8px 12px;display:flex;gap:12px;align-items:center}
+.method{font:bold 12px monospace;text-transform:uppercase;color:#fff;border-radius:4px;padding:2px 8px;min-width:56px;text-align:center}
+.get{background:#0969da}.post{background:#1a7f37}.put{background:#9a6700}.patch{background:#8250df}.delete{background:#cf222e}.head,.options{background:#59636e}
+.path{font-family:monospace;font-weight:600}
+.body{padding:0 16px 12px;border-top:1px solid #d1d9e0}
+table{border-collapse:collapse;width:100%}
+td,th{text-align:left;padding:4px 8px;border-bottom:1px solid #eee;vertical-align:top}
+code,pre{font:12px monospace}
+pre{background:#f6f8fa;padding:8px;border-radius:4px;overflow:auto}
+.tag{font-size:12px;color:#59636e}
+</style>
+</head>
+<body>
+<main id="app">Loading...</main>
+<script>
+const specURL = {{SPEC_URL}};
+const app = document.getElementById("app");
+const esc = (s) => String(s ?? "").replace(/[&<>"]/g, (c) => ({"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;"})[c]);
+fetch(specURL).then((res) => res.json()).then((spec) => {
+  const schemas = (spec.components || {}).schemas || {};
+  const resolve = (s) => s && s.$ref ? schemas[s.$ref.split("/").pop()] || {} : s || {};
+  const typeOf = (s) => {
+    if (!s) return "any";
+    if (s.$ref) return s.$ref.split("/").pop();
+    if (s.type === "array") return typeOf(s.items) + "[]";
+    if (s.enum) return s.enum.map((v) => JSON.stringify(v)).join(" | ");
+    return (s.type || "any") + (s.format ? " <" + s.format + ">" : "");
+  };
+  const example = (s, seen = new Set()) => {
+    if (s && s.$ref) {
+      if (seen.has(s.$ref)) return {};
+      seen = new Set(seen).add(s.$ref);
+    }
+    s = resolve(s);
+    if (s.enum) return s.enum[0];
+    switch (s.type) {
+      case "object":
+        if (!s.properties) return {};
+        return Object.fromEntries(Object.entries(s.properties).map(([k, v]) => [k, example(v, seen)]));
+      case "array": return [example(s.items, seen)];
+      case "integer": case "number": return 0;
+      case "boolean": return false;
+      case "string": return s.format === "date-time" ? new Date(0).toISOString() : "string";
+    }
+    return null;
+  };
+  const schemaBlock = (s) => {
+    const r = resolve(s);
+    let html = "<p><code>" + esc(typeOf(s)) + "</code></p>";
+    if (r.properties) {
+      const required = new Set(r.required || []);
+      html += "<table><tr><th>Field</th><th>Type</th><th></th></tr>" + Object.entries(r.properties).map(([k, v]) =>
+        "<tr><td><code>" + esc(k) + "</code></td><td><code>" + esc(typeOf(v)) + "</code></td><td>" + (required.has(k) ? "required" : "") + "</td></tr>").join("") + "</table>";
+    }
+    return html + "<pre>" + esc(JSON.stringify(example(s), null, 2)) + "</pre>";
+  };
+  let html = "<h1>" + esc(spec.info.title) + " <small>" + esc(spec.info.version) + "</small></h1>";
+  if (spec.info.description) html += "<p class=desc>" + esc(spec.info.description) + "</p>";
+  for (const [path, item] of Object.entries(spec.paths || {})) {
+    for (const [method, op] of Object.entries(item)) {
+      html += "<details><summary><span class='method " + esc(method) + "'>" + esc(method) + "</span><span class=path>" + esc(path) + "</span><span>" + esc(op.summary) + "</span>" +
+        (op.deprecated ? "<s>deprecated</s>" : "") + "<span class=tag>" + esc((op.tags || []).join(", ")) + "</span></summary><div class=body>";
+      if (op.description) html += "<p class=desc>" + esc(op.description) + "</p>";
+      if (op.parameters) {
+        html += "<h4>Parameters</h4><table><tr><th>Name</th><th>In</th><th>Type</th><th></th></tr>" + op.parameters.map((p) =>
+          "<tr><td><code>" + esc(p.name) + "</code></td><td>" + esc(p.in) + "</td><td><code>" + esc(typeOf(p.schema)) + "</code></td><td>" + (p.required ? "required" : "") + "</td></tr>").join("") + "</table>";
+      }
+      if (op.requestBody) html += "<h4>Request Body</h4>" + schemaBlock(op.requestBody.content["application/json"].schema);
+      for (const [code, res] of Object.entries(op.responses || {})) {
+        html += "<h4>Response " + esc(code) + " <small>" + esc(res.description) + "</small></h4>";
+        if (res.content) html += schemaBlock(res.content["application/json"].schema);
+      }
+      html += "</div></details>";
+    }
+  }
+  app.innerHTML = html;
+}).catch((err) => {
+  app.textContent = "Failed to load " + specURL + ": " + err.message;
+});
+</script>
+</body>
+</html>
+`
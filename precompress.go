@@ -0,0 +1,146 @@
+package rex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// precompressedEncoding is a content coding of the precompressed files.
+type precompressedEncoding struct {
+	name string
+	ext  string
+}
+
+// precompressedEncodings are the supported precompressed files in the server
+// preference order.
+var precompressedEncodings = []precompressedEncoding{
+	{"br", ".br"},
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
+// isPrecompressedFile reports whether the file is a precompressed sibling.
+func isPrecompressedFile(name string) bool {
+	ext := filepath.Ext(name)
+	for _, e := range precompressedEncodings {
+		if ext == e.ext {
+			return true
+		}
+	}
+	return false
+}
+
+// openPrecompressed opens the precompressed sibling of the file that is most
+// preferred by the Accept-Encoding header. The exists is true if the file has
+// any precompressed siblings, the response should vary by the Accept-Encoding then.
+func (s *fileServer) openPrecompressed(name string, acceptEncoding string) (encoding string, file fs.File, fi fs.FileInfo, exists bool) {
+	list := parseQualityList(acceptEncoding)
+	bestQ := 0.0
+	for _, e := range precompressedEncodings {
+		sfi, err := fs.Stat(s.fsys, name+e.ext)
+		if err != nil || sfi.IsDir() {
+			continue
+		}
+		exists = true
+		if q := encodingQuality(list, e.name); q > bestQ {
+			bestQ = q
+			encoding = e.name
+			fi = sfi
+		}
+	}
+	if encoding == "" {
+		return "", nil, nil, exists
+	}
+	for _, e := range precompressedEncodings {
+		if e.name == encoding {
+			f, err := s.fsys.Open(name + e.ext)
+			if err != nil {
+				return "", nil, nil, exists
+			}
+			file = f
+		}
+	}
+	return encoding, file, fi, exists
+}
+
+// PrecompressDir writes the .br, .gz and .zst siblings of the text files in the
+// directory at the best compression level, the static file servers serve them
+// directly to the clients that accept the encodings. The files smaller than
+// 1KB are skipped, the siblings that are not smaller than the files are not
+// written, and the existing siblings are kept unless they are older than the files.
+func PrecompressDir(root string) error {
+	return filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() || isPrecompressedFile(name) || !isTextFile(name) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if fi.Size() < compressMinSize {
+			return nil
+		}
+		var data []byte
+		for _, e := range precompressedEncodings {
+			target := name + e.ext
+			if tfi, err := os.Stat(target); err == nil && !tfi.ModTime().Before(fi.ModTime()) {
+				continue
+			}
+			if data == nil {
+				data, err = os.ReadFile(name)
+				if err != nil {
+					return err
+				}
+			}
+			compressed, err := compressBest(e.name, data)
+			if err != nil {
+				return err
+			}
+			if len(compressed) >= len(data) {
+				os.Remove(target)
+				continue
+			}
+			if err := os.WriteFile(target, compressed, fi.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// compressBest compresses the data at the best compression level of the encoding.
+func compressBest(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "br":
+		w = brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	case "gzip":
+		w, _ = gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	case "zstd":
+		zw, err := zstd.NewWriter(&buf, zstd.WithEncoderLevel(zstd.SpeedBestCompression), zstd.WithWindowSize(zstdWindowSize))
+		if err != nil {
+			return nil, err
+		}
+		w = zw
+	default:
+		return nil, fs.ErrInvalid
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
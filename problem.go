@@ -0,0 +1,161 @@
+package rex
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+)
+
+// Problem is a problem details object defined in RFC 9457, the Extensions
+// members are flattened into the JSON object.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]any
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	if p.Type != "" {
+		m["type"] = p.Type
+	}
+	if p.Title != "" {
+		m["title"] = p.Title
+	}
+	if p.Status != 0 {
+		m["status"] = p.Status
+	}
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	return json.Marshal(m)
+}
+
+// ProblemDetails is an error handler that replies the errors in the problem details format
+// defined in RFC 9457. The response is encoded in JSON, HTML or plain text by the Accept header.
+// The details of the server errors are not exposed to the client.
+//
+//	rex.ErrorHandler(rex.ProblemDetails)
+func ProblemDetails(ctx *Context, err error) any {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+	status := errorStatusCode(err)
+	p = &Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Instance: ctx.R.URL.Path,
+	}
+	if status < 500 {
+		var e *Error
+		var detail string
+		if errors.As(err, &e) {
+			detail = e.Message
+			if len(e.Fields) > 0 {
+				p.Extensions = map[string]any{"errors": e.Fields}
+			}
+		} else if e, ok := err.(*invalid); ok {
+			detail = e.message
+		}
+		if detail != p.Title {
+			p.Detail = detail
+		}
+	}
+	return p
+}
+
+// respondWithProblem replies to the request with the problem details in the format
+// negotiated by the Accept header.
+func (ctx *Context) respondWithProblem(p *Problem) {
+	w := ctx.W
+	h := w.Header()
+	status := p.Status
+	if status == 0 {
+		status = 500
+	}
+	buf := bytes.NewBuffer(nil)
+	switch negotiate(ctx.R.Header.Get("Accept"), "application/problem+json", "application/json", "text/html", "text/plain") {
+	case "text/html":
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		title := html.EscapeString(p.Title)
+		fmt.Fprintf(buf, "<!DOCTYPE html>\n<html>\n<head><title>%d %s</title></head>\n<body>\n<h1>%d %s</h1>\n", status, title, status, title)
+		if p.Detail != "" {
+			fmt.Fprintf(buf, "<p>%s</p>\n", html.EscapeString(p.Detail))
+		}
+		buf.WriteString("</body>\n</html>\n")
+	case "text/plain":
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(buf, "%d %s", status, p.Title)
+		if p.Detail != "" {
+			fmt.Fprintf(buf, ": %s", p.Detail)
+		}
+	default:
+		if _, ok := p.Extensions["request_id"]; ctx.requestID != "" && !ok {
+			// add the request ID without changing the problem of the caller
+			cp := *p
+			cp.Extensions = make(map[string]any, len(p.Extensions)+1)
+			for k, v := range p.Extensions {
+				cp.Extensions[k] = v
+			}
+			cp.Extensions["request_id"] = ctx.requestID
+			p = &cp
+		}
+		err := json.NewEncoder(buf).Encode(p)
+		if err != nil {
+			ctx.respondWithError(err)
+			return
+		}
+		h.Set("Content-Type", "application/problem+json")
+	}
+	h.Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// panicError is the error of a recovered panic.
+type panicError struct {
+	value any
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// errorStatusCode returns the http status code of the error.
+func errorStatusCode(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	var p *Problem
+	if errors.As(err, &p) && p.Status != 0 {
+		return p.Status
+	}
+	if e, ok := err.(*invalid); ok {
+		return e.code
+	}
+	return 500
+}
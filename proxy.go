@@ -0,0 +1,166 @@
+package rex
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies sets the networks of the trusted proxies, the forwarding
+// headers (Forwarded, X-Forwarded-For, X-Real-IP, X-Forwarded-Proto and
+// X-Forwarded-Host) are used only if the peer is a trusted proxy. No proxies are
+// trusted by default, the networks of the proxies must be listed explicitly.
+func (a *Mux) TrustedProxies(prefixes []netip.Prefix) {
+	a.trustedProxies = prefixes
+}
+
+// forwarded is the parsed forwarding information of a request.
+type forwarded struct {
+	ip    string
+	proto string
+	host  string
+}
+
+// resolveForwarded walks the forwarding headers from right to left, skipping
+// the trusted hops, the first untrusted hop is the client.
+func resolveForwarded(r *http.Request, trusted []netip.Prefix) forwarded {
+	peer, ok := parseHostAddr(r.RemoteAddr)
+	if !ok {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		return forwarded{ip: host}
+	}
+	f := forwarded{ip: peer.String()}
+	if !isTrustedProxy(peer, trusted) {
+		return f
+	}
+
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		elements := parseForwardedHeader(values)
+		for i := len(elements) - 1; i >= 0; i-- {
+			e := elements[i]
+			addr, ok := parseHostAddr(e["for"])
+			if !ok {
+				break
+			}
+			f.ip = addr.String()
+			f.proto = e["proto"]
+			f.host = e["host"]
+			if !isTrustedProxy(addr, trusted) {
+				break
+			}
+		}
+		return f
+	}
+
+	if hops := headerList(r.Header, "X-Forwarded-For"); len(hops) > 0 {
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, ok := parseHostAddr(hops[i])
+			if !ok {
+				break
+			}
+			f.ip = addr.String()
+			if !isTrustedProxy(addr, trusted) {
+				break
+			}
+		}
+	} else if addr, ok := parseHostAddr(r.Header.Get("X-Real-IP")); ok {
+		f.ip = addr.String()
+	}
+	// the rightmost value is set by the nearest proxy
+	if protos := headerList(r.Header, "X-Forwarded-Proto"); len(protos) > 0 {
+		f.proto = protos[len(protos)-1]
+	}
+	if hosts := headerList(r.Header, "X-Forwarded-Host"); len(hosts) > 0 {
+		f.host = hosts[len(hosts)-1]
+	}
+	return f
+}
+
+// isTrustedProxy reports whether the addr is in the trusted networks.
+func isTrustedProxy(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHostAddr parses an IP address with an optional port, the IPv6 address
+// may be enclosed in square brackets.
+func parseHostAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return netip.Addr{}, false
+	}
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// parseForwardedHeader parses the Forwarded header defined in RFC 7239,
+// the parameter names are lowercased and the quoted values are unquoted.
+func parseForwardedHeader(values []string) []map[string]string {
+	var elements []map[string]string
+	for _, v := range values {
+		e := map[string]string{}
+		for len(v) > 0 {
+			var pair string
+			var sep byte
+			pair, sep, v = cutForwardedPair(v)
+			name, value, _ := strings.Cut(pair, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			value = strings.TrimSpace(value)
+			if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = strings.ReplaceAll(value[1:len(value)-1], `\`, "")
+			}
+			if name != "" {
+				e[name] = value
+			}
+			if sep == ',' {
+				elements = append(elements, e)
+				e = map[string]string{}
+			}
+		}
+		elements = append(elements, e)
+	}
+	return elements
+}
+
+// cutForwardedPair cuts the string at the first ';' or ',' outside the quotes.
+func cutForwardedPair(s string) (pair string, sep byte, rest string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case (c == ';' || c == ',') && !quoted:
+			return s[:i], c, s[i+1:]
+		}
+	}
+	return s, 0, ""
+}
+
+// headerList returns the comma-separated values of the header.
+func headerList(header http.Header, name string) []string {
+	var list []string
+	for _, v := range header.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
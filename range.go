@@ -0,0 +1,183 @@
+package rex
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+// httpRange is a byte range of the content.
+type httpRange struct {
+	start, length int64
+}
+
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses the Range header defined in RFC 9110, it returns nil if the
+// header is malformed or the ranges should be ignored, and errUnsatisfiableRange
+// if none of the ranges is satisfiable.
+func parseRange(s string, size int64) ([]httpRange, error) {
+	unit, set, ok := strings.Cut(s, "=")
+	if !ok || strings.TrimSpace(unit) != "bytes" {
+		return nil, nil
+	}
+	var ranges []httpRange
+	unsatisfiable := 0
+	for _, spec := range strings.Split(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, nil
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+		var r httpRange
+		if first == "" {
+			// the suffix range "-N" selects the last N bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				unsatisfiable++
+				continue
+			}
+			n = min(n, size)
+			r = httpRange{size - n, n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, nil
+				}
+				end = min(end, size-1)
+			}
+			if start >= size {
+				unsatisfiable++
+				continue
+			}
+			r = httpRange{start, end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		if unsatisfiable > 0 {
+			return nil, errUnsatisfiableRange
+		}
+		return nil, nil
+	}
+	// ignore the ranges that ask for more than the content, it may be an attack
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	if total > size {
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// checkIfRange reports whether the Range header should be applied, the If-Range
+// header matches the strong ETag or the modification time of the content.
+func checkIfRange(r *http.Request, etag string, modtime time.Time) bool {
+	ir := strings.TrimSpace(r.Header.Get("If-Range"))
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) {
+		return etag != "" && !strings.HasPrefix(etag, "W/") && ir == etag
+	}
+	if strings.HasPrefix(ir, "W/") || modtime.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ir)
+	if err != nil {
+		return false
+	}
+	return t.Equal(modtime.Truncate(time.Second))
+}
+
+// respondWithRanges replies the ranges of the content with 206, the ranges are
+// sent in the multipart/byteranges format if there are more than one.
+func (ctx *Context) respondWithRanges(content io.ReadSeeker, size int64, ranges []httpRange) {
+	w := ctx.W
+	h := w.Header()
+	if len(ranges) == 1 {
+		ra := ranges[0]
+		h.Set("Content-Range", ra.contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		w.WriteHeader(206)
+		if ctx.R.Method != "HEAD" {
+			if _, err := content.Seek(ra.start, io.SeekStart); err == nil {
+				io.CopyN(w, content, ra.length)
+			}
+		}
+		return
+	}
+
+	ctype := h.Get("Content-Type")
+	partHeader := func(ra httpRange) textproto.MIMEHeader {
+		ph := textproto.MIMEHeader{"Content-Range": {ra.contentRange(size)}}
+		if ctype != "" {
+			ph.Set("Content-Type", ctype)
+		}
+		return ph
+	}
+
+	// compute the length of the multipart body with the same boundary
+	var counter countWriter
+	mw := multipart.NewWriter(&counter)
+	for _, ra := range ranges {
+		mw.CreatePart(partHeader(ra))
+		counter += countWriter(ra.length)
+	}
+	mw.Close()
+
+	h.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	h.Set("Content-Length", strconv.FormatInt(int64(counter), 10))
+	w.WriteHeader(206)
+	if ctx.R.Method == "HEAD" {
+		return
+	}
+	boundary := mw.Boundary()
+	mw = multipart.NewWriter(w)
+	mw.SetBoundary(boundary)
+	for _, ra := range ranges {
+		pw, err := mw.CreatePart(partHeader(ra))
+		if err != nil {
+			return
+		}
+		if _, err := content.Seek(ra.start, io.SeekStart); err != nil {
+			return
+		}
+		if _, err := io.CopyN(pw, content, ra.length); err != nil {
+			return
+		}
+	}
+	mw.Close()
+}
+
+// countWriter counts the bytes written.
+type countWriter int64
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	*w += countWriter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,268 @@
+package rex
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitAlgorithm is the algorithm to limit the request rate.
+type RateLimitAlgorithm int
+
+const (
+	// RateLimitTokenBucket refills the bucket at the rate of Limit per Window,
+	// the bucket holds at most Burst tokens.
+	RateLimitTokenBucket RateLimitAlgorithm = iota
+	// RateLimitSlidingWindow allows Limit requests in any Window, the count
+	// of the previous window is weighted by its overlap with the sliding window.
+	RateLimitSlidingWindow
+)
+
+// RateLimitPolicy defines the quota of a rate limit.
+type RateLimitPolicy struct {
+	Algorithm RateLimitAlgorithm
+	Limit     int
+	Window    time.Duration
+	Burst     int
+}
+
+// RateLimitResult is the result of taking a request from the quota.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// RateLimitStore is the storage of the rate limit states, the Take method
+// must be atomic so a store can be shared by multiple instances.
+type RateLimitStore interface {
+	Take(key string, policy RateLimitPolicy) (RateLimitResult, error)
+}
+
+// RateLimitOptions contains the options for the RateLimit middleware.
+type RateLimitOptions struct {
+	// Algorithm is the rate limit algorithm, default is the token bucket.
+	Algorithm RateLimitAlgorithm
+	// Limit is the number of requests allowed in the Window.
+	Limit int
+	// Window is the time window of the Limit, default is one minute.
+	Window time.Duration
+	// Burst is the capacity of the token bucket, default is the Limit.
+	Burst int
+	// Key returns the key of the client, default is the KeyByRemoteIP.
+	Key func(ctx *Context) string
+	// Store is the storage of the rate limit states, default is a memory store.
+	Store RateLimitStore
+}
+
+// KeyByRemoteIP returns the remote IP as the rate limit key.
+func KeyByRemoteIP(ctx *Context) string {
+	return "ip:" + ctx.RemoteIP()
+}
+
+// KeyByBasicAuthUser returns the basic auth user as the rate limit key,
+// the remote IP is used if the user is not authorized.
+func KeyByBasicAuthUser(ctx *Context) string {
+	if user := ctx.BasicAuthUser(); user != "" {
+		return "user:" + user
+	}
+	return KeyByRemoteIP(ctx)
+}
+
+// KeyByAclUser returns the ACL user as the rate limit key, the user should
+// implement the fmt.Stringer interface to return an unique ID. The remote IP
+// is used if the user is not authorized.
+func KeyByAclUser(ctx *Context) string {
+	if user := ctx.AclUser(); user != nil {
+		if s, ok := user.(fmt.Stringer); ok {
+			return "acl:" + s.String()
+		}
+		return fmt.Sprintf("acl:%v", user)
+	}
+	return KeyByRemoteIP(ctx)
+}
+
+// RateLimit returns a rate limit middleware, the requests exceeding the limit
+// are replied with 429. The RateLimit-* headers are set for all requests.
+func RateLimit(opts RateLimitOptions) Handle {
+	policy := RateLimitPolicy{
+		Algorithm: opts.Algorithm,
+		Limit:     opts.Limit,
+		Window:    opts.Window,
+		Burst:     opts.Burst,
+	}
+	if policy.Limit <= 0 {
+		panic("rate limit: invalid limit")
+	}
+	if policy.Window <= 0 {
+		policy.Window = time.Minute
+	}
+	if policy.Burst <= 0 {
+		policy.Burst = policy.Limit
+	}
+	key := opts.Key
+	if key == nil {
+		key = KeyByRemoteIP
+	}
+	store := opts.Store
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+	quota, window := policy.Limit, policy.Window
+	if policy.Algorithm == RateLimitTokenBucket {
+		// the quota of the token bucket is its capacity, which is refilled in
+		// the window of Burst/Limit times the Window
+		quota = policy.Burst
+		window = time.Duration(float64(policy.Window) * float64(policy.Burst) / float64(policy.Limit))
+	}
+	policyHeader := fmt.Sprintf("%d;w=%d", quota, int64(math.Ceil(window.Seconds())))
+	return func(ctx *Context) any {
+		ret, err := store.Take(key(ctx), policy)
+		if err != nil {
+			// don't block the requests if the store is unavailable
+			ctx.logf("error", "rate limit: %v", err)
+			return next
+		}
+		h := ctx.header
+		h.Set("RateLimit-Policy", policyHeader)
+		h.Set("RateLimit-Limit", strconv.Itoa(ret.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(ret.Remaining))
+		h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(ret.Reset), 10))
+		if !ret.Allowed {
+			h.Set("Retry-After", strconv.FormatInt(ceilSeconds(ret.RetryAfter), 10))
+			return &invalid{429, "Too Many Requests"}
+		}
+		return next
+	}
+}
+
+// ceilSeconds returns the duration in seconds, rounded up.
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
+
+const rateLimitShards = 64
+
+// MemoryRateLimitStore is a sharded in-memory RateLimitStore.
+type MemoryRateLimitStore struct {
+	shards [rateLimitShards]rateLimitShard
+	now    func() time.Time
+}
+
+type rateLimitShard struct {
+	lock      sync.Mutex
+	entries   map[string]*rateLimitEntry
+	lastSweep time.Time
+}
+
+type rateLimitEntry struct {
+	// the token bucket state
+	tokens float64
+	last   time.Time
+	// the sliding window state
+	start    time.Time
+	count    int
+	previous int
+	// expires is the time when the entry returns to the initial state
+	expires time.Time
+}
+
+// NewMemoryRateLimitStore returns a new MemoryRateLimitStore.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{now: time.Now}
+	for i := range s.shards {
+		s.shards[i].entries = map[string]*rateLimitEntry{}
+	}
+	return s
+}
+
+// Take implements the RateLimitStore interface.
+func (s *MemoryRateLimitStore) Take(key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := &s.shards[h.Sum32()%rateLimitShards]
+	now := s.now()
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	// remove the expired entries periodically
+	if now.Sub(shard.lastSweep) >= time.Minute {
+		for k, e := range shard.entries {
+			if !now.Before(e.expires) {
+				delete(shard.entries, k)
+			}
+		}
+		shard.lastSweep = now
+	}
+
+	e, ok := shard.entries[key]
+	if !ok {
+		e = &rateLimitEntry{tokens: float64(policy.Burst), last: now, start: now.Truncate(policy.Window)}
+		shard.entries[key] = e
+	}
+	if policy.Algorithm == RateLimitSlidingWindow {
+		return e.slidingWindow(policy, now), nil
+	}
+	return e.tokenBucket(policy, now), nil
+}
+
+func (e *rateLimitEntry) tokenBucket(policy RateLimitPolicy, now time.Time) RateLimitResult {
+	rate := float64(policy.Limit) / policy.Window.Seconds() // tokens per second
+	capacity := float64(policy.Burst)
+	if elapsed := now.Sub(e.last).Seconds(); elapsed > 0 {
+		e.tokens = math.Min(capacity, e.tokens+elapsed*rate)
+	}
+	e.last = now
+
+	ret := RateLimitResult{Limit: policy.Burst}
+	if e.tokens >= 1 {
+		e.tokens--
+		ret.Allowed = true
+	} else {
+		ret.RetryAfter = time.Duration((1 - e.tokens) / rate * float64(time.Second))
+	}
+	ret.Remaining = int(e.tokens)
+	ret.Reset = time.Duration((capacity - e.tokens) / rate * float64(time.Second))
+	e.expires = now.Add(ret.Reset)
+	return ret
+}
+
+func (e *rateLimitEntry) slidingWindow(policy RateLimitPolicy, now time.Time) RateLimitResult {
+	window := policy.Window
+	if elapsed := now.Sub(e.start); elapsed >= window {
+		if elapsed < 2*window {
+			e.previous = e.count
+		} else {
+			e.previous = 0
+		}
+		e.count = 0
+		e.start = now.Truncate(window)
+	}
+	elapsed := now.Sub(e.start)
+	weight := float64(window-elapsed) / float64(window)
+	estimated := float64(e.previous)*weight + float64(e.count)
+
+	ret := RateLimitResult{Limit: policy.Limit, Reset: window - elapsed}
+	if estimated+1 <= float64(policy.Limit) {
+		e.count++
+		estimated++
+		ret.Allowed = true
+	} else if available := float64(policy.Limit - 1 - e.count); available >= 0 && e.previous > 0 {
+		// wait until the weighted count of the previous window drops enough
+		ret.RetryAfter = time.Duration((1-available/float64(e.previous))*float64(window)) - elapsed
+	} else {
+		ret.RetryAfter = window - elapsed
+	}
+	ret.Remaining = max(0, policy.Limit-int(math.Ceil(estimated)))
+	e.expires = e.start.Add(2 * window)
+	return ret
+}
@@ -0,0 +1,132 @@
+package rex
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// RequestIDFormat is the format of the generated request IDs.
+type RequestIDFormat int
+
+const (
+	// RequestIDUUIDv7 generates the time-ordered UUIDs defined in RFC 9562.
+	RequestIDUUIDv7 RequestIDFormat = iota
+	// RequestIDULID generates the ULIDs.
+	RequestIDULID
+)
+
+// RequestIDOptions contains the options for the RequestID middleware.
+type RequestIDOptions struct {
+	// Header is the header to read and echo the request ID, default is "X-Request-ID".
+	Header string
+	// Format is the format of the generated request IDs, default is UUIDv7.
+	Format RequestIDFormat
+	// Generate generates the request IDs, it overrides the Format.
+	Generate func() string
+	// MaxLength is the max length of the incoming request IDs, default is 128.
+	MaxLength int
+	// IgnoreIncoming ignores the incoming request IDs.
+	IgnoreIncoming bool
+}
+
+// RequestID returns a middleware that sets the request ID, the incoming request
+// ID is used if it's valid, or a new one is generated. The request ID is echoed
+// in the response, and included in the error logs, access logs and problem details.
+func RequestID(opts RequestIDOptions) Handle {
+	header := opts.Header
+	if header == "" {
+		header = "X-Request-ID"
+	}
+	maxLength := opts.MaxLength
+	if maxLength <= 0 {
+		maxLength = 128
+	}
+	generate := opts.Generate
+	if generate == nil {
+		if opts.Format == RequestIDULID {
+			generate = NewULID
+		} else {
+			generate = NewUUIDv7
+		}
+	}
+	return func(ctx *Context) any {
+		id := ""
+		if !opts.IgnoreIncoming {
+			id = ctx.R.Header.Get(header)
+			if !validRequestID(id, maxLength) {
+				id = ""
+			}
+		}
+		if id == "" {
+			id = generate()
+			// pass the request ID to the wrapped handlers and proxies
+			ctx.R.Header.Set(header, id)
+		}
+		ctx.requestID = id
+		ctx.header.Set(header, id)
+		return next
+	}
+}
+
+// validRequestID reports whether the request ID is safe to log and echo.
+func validRequestID(id string, maxLength int) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '+', c == '=', c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewUUIDv7 returns a new UUID version 7 defined in RFC 9562.
+func NewUUIDv7() string {
+	var u [16]byte
+	rand.Read(u[6:])
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(u[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(u[2:], uint32(ms))
+	u[6] = u[6]&0x0f | 0x70 // version 7
+	u[8] = u[8]&0x3f | 0x80 // variant 10
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf)
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a new ULID, a 48-bit timestamp and 80-bit randomness
+// encoded in 26 characters of Crockford's base32.
+func NewULID() string {
+	var u [16]byte
+	rand.Read(u[6:])
+	ms := uint64(time.Now().UnixMilli())
+	binary.BigEndian.PutUint16(u[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(u[2:], uint32(ms))
+	// encode 128 bits in 26 characters, the first character has 3 bits
+	hi := binary.BigEndian.Uint64(u[0:8])
+	lo := binary.BigEndian.Uint64(u[8:16])
+	buf := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		buf[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf)
+}
@@ -2,11 +2,16 @@ package rex
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 )
@@ -16,6 +21,10 @@ type invalid struct {
 	message string
 }
 
+func (e *invalid) Error() string {
+	return e.message
+}
+
 // Invalid returns an invalid error with code and message.
 func Invalid(code int, v ...string) any {
 	var messsage string
@@ -27,10 +36,12 @@ func Invalid(code int, v ...string) any {
 	return &invalid{code, messsage}
 }
 
-// Error defines an error with code and message.
+// Error defines an error with code and message, the optional Fields
+// contains the messages of the invalid fields.
 type Error struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code    int               `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
 }
 
 func (e *Error) Error() string {
@@ -54,6 +65,15 @@ func Err(code int, v ...string) any {
 	}
 }
 
+type jsonValue struct {
+	value any
+}
+
+// JSON replies to the request with the JSON encoding of v.
+func JSON(v any) any {
+	return &jsonValue{v}
+}
+
 type redirect struct {
 	status int
 	url    string
@@ -151,9 +171,21 @@ func File(name string) any {
 	return &content{path.Base(name), fi.ModTime(), file}
 }
 
-type fs struct {
-	root     string
-	fallback string
+// FSOptions contains the options for the FSFrom and StaticFS.
+type FSOptions struct {
+	// Fallback is the file to reply if the requested file is not found,
+	// e.g. "index.html" for the single page applications.
+	Fallback string
+	// Immutable indicates the files never change, the ETags are computed from
+	// the file contents once. The files without modification time, like the
+	// files of embed.FS, are always treated as immutable.
+	Immutable bool
+}
+
+type fileServer struct {
+	fsys  fs.FS
+	opts  FSOptions
+	etags sync.Map
 }
 
 // FS replies to the request with the contents of the file system rooted at root.
@@ -165,5 +197,72 @@ func FS(root string, fallback string) any {
 	if !fi.IsDir() {
 		panic(&invalid{500, "FS root is not a directory"})
 	}
-	return &fs{root, fallback}
+	return &fileServer{fsys: os.DirFS(root), opts: FSOptions{Fallback: fallback}}
+}
+
+// FSFrom replies to the request with the contents of the fsys, e.g. an embed.FS.
+// The returned value should be created once and reused since it caches the ETags.
+func FSFrom(fsys fs.FS, opts ...FSOptions) any {
+	s := &fileServer{fsys: fsys}
+	if len(opts) > 0 {
+		s.opts = opts[0]
+	}
+	return s
+}
+
+// open opens the file of the request path, the index.html of the directory,
+// the file with the .html extension or the fallback file in order.
+func (s *fileServer) open(pathname string) (string, fs.File, fs.FileInfo, error) {
+	name := strings.TrimPrefix(path.Clean("/"+pathname), "/")
+	if name == "" {
+		name = "."
+	}
+	fi, err := fs.Stat(s.fsys, name)
+	if err == nil && fi.IsDir() {
+		name = path.Join(name, "index.html")
+		fi, err = fs.Stat(s.fsys, name)
+	}
+	if errors.Is(err, fs.ErrNotExist) && !strings.HasSuffix(name, ".html") && name != "." {
+		fi, err = fs.Stat(s.fsys, name+".html")
+		if err == nil {
+			name += ".html"
+		}
+	}
+	if errors.Is(err, fs.ErrNotExist) && s.opts.Fallback != "" {
+		name = strings.TrimPrefix(path.Clean("/"+s.opts.Fallback), "/")
+		fi, err = fs.Stat(s.fsys, name)
+	}
+	if err == nil && fi.IsDir() {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		return "", nil, nil, err
+	}
+	file, err := s.fsys.Open(name)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return name, file, fi, nil
+}
+
+// etag returns the hash-based ETag of an immutable file, it's computed once.
+func (s *fileServer) etag(name string, fi fs.FileInfo) string {
+	if !s.opts.Immutable && !fi.ModTime().IsZero() {
+		return ""
+	}
+	if v, ok := s.etags.Load(name); ok {
+		return v.(string)
+	}
+	file, err := s.fsys.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return ""
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+	s.etags.Store(name, etag)
+	return etag
 }
@@ -0,0 +1,88 @@
+package rex
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Route describes a registered route, the metadata is used to generate the OpenAPI document.
+type Route struct {
+	Pattern     string
+	Method      string
+	Host        string
+	Path        string
+	Params      []string
+	Summary     string
+	Description string
+	Tags        []string
+	Deprecated  bool
+	Hidden      bool
+	Request     reflect.Type
+	Response    reflect.Type
+}
+
+// Doc sets the summary and tags of the route.
+func (r *Route) Doc(summary string, tags ...string) *Route {
+	r.Summary = summary
+	r.Tags = append(r.Tags, tags...)
+	return r
+}
+
+// Types overrides the request and response types of the route with the types of
+// the values, the types of the Typed handles are recorded automatically. The
+// types are used to generate the schemas of the OpenAPI document, a nil value
+// leaves the type unchanged.
+func (r *Route) Types(in any, out any) *Route {
+	if in != nil {
+		r.Request = reflect.TypeOf(in)
+	}
+	if out != nil {
+		r.Response = reflect.TypeOf(out)
+	}
+	return r
+}
+
+// Hide excludes the route from the OpenAPI document.
+func (r *Route) Hide() *Route {
+	r.Hidden = true
+	return r
+}
+
+// newRoute creates a Route from the pattern.
+func newRoute(pattern string) *Route {
+	method, host, path := parsePattern(pattern)
+	route := &Route{
+		Pattern: pattern,
+		Method:  method,
+		Host:    host,
+		Path:    path,
+	}
+	for s := path; ; {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			break
+		}
+		name := strings.TrimSuffix(s[i+1:i+j], "...")
+		if name != "$" && name != "" {
+			route.Params = append(route.Params, name)
+		}
+		s = s[i+j+1:]
+	}
+	return route
+}
+
+// parsePattern splits the pattern "[METHOD ][HOST]/[PATH]" into parts.
+func parsePattern(pattern string) (method string, host string, path string) {
+	if i := strings.IndexAny(pattern, " \t"); i >= 0 {
+		method, pattern = pattern[:i], strings.TrimLeft(pattern[i+1:], " \t")
+	}
+	i := strings.IndexByte(pattern, '/')
+	if i < 0 {
+		panic("rex: invalid route pattern: " + pattern)
+	}
+	return method, pattern[:i], pattern[i:]
+}
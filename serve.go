@@ -3,32 +3,44 @@ package rex
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/acme/autocert"
 )
 
 // ServerConfig contains options to run the REX server.
+// The default mux is used to handle requests if the Handler is nil.
+// When the context is done, the server stops accepting new connections, ends
+// the event streams and waits up to ShutdownTimeout seconds (default is 30) for
+// in-flight requests, then the OnShutdown hooks are called.
 type ServerConfig struct {
-	Host           string
-	Port           uint16
-	TLS            TLSConfig
-	ReadTimeout    uint32
-	WriteTimeout   uint32
-	MaxHeaderBytes uint32
+	Handler         http.Handler
+	Host            string
+	Port            uint16
+	TLS             TLSConfig
+	ReadTimeout     uint32
+	WriteTimeout    uint32
+	MaxHeaderBytes  uint32
+	ShutdownTimeout uint32
+	OnShutdown      []func()
 }
 
 // TLSConfig contains options to support https.
+// If AutoRedirect is true, the http server redirects all requests to the https server.
 type TLSConfig struct {
 	Port         uint16
 	CertFile     string
 	KeyFile      string
 	AutoTLS      AutoTLSConfig
 	AutoRedirect bool
+	HSTS         HSTSConfig
 }
 
 // AutoTLSConfig contains options to support autocert by Let's Encrypto SSL.
@@ -39,41 +51,80 @@ type AutoTLSConfig struct {
 	Cache     autocert.Cache
 }
 
+// HSTSConfig contains options of the Strict-Transport-Security header,
+// the header is sent by the https server if MaxAge is greater than zero.
+type HSTSConfig struct {
+	MaxAge            uint32
+	IncludeSubDomains bool
+	Preload           bool
+}
+
+// wrap returns a handler that sets the Strict-Transport-Security header.
+func (config HSTSConfig) wrap(handler http.Handler) http.Handler {
+	if config.MaxAge == 0 {
+		return handler
+	}
+	value := "max-age=" + strconv.FormatUint(uint64(config.MaxAge), 10)
+	if config.IncludeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if config.Preload {
+		value += "; preload"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// handler returns the handler of the server.
+func (config *ServerConfig) handler() http.Handler {
+	if config.Handler != nil {
+		return config.Handler
+	}
+	return defaultMux
+}
+
+// shutdown calls the OnShutdown hooks.
+func (config *ServerConfig) shutdown() {
+	for _, fn := range config.OnShutdown {
+		if fn != nil {
+			fn()
+		}
+	}
+}
+
 // serve starts a REX server.
-func serve(ctx context.Context, config *ServerConfig, c chan error) error {
+func serve(ctx context.Context, config *ServerConfig, handler http.Handler, c chan error) error {
 	port := config.Port
 	if port == 0 {
 		port = 80
 	}
 	serv := &http.Server{
 		Addr:           fmt.Sprintf(("%s:%d"), config.Host, port),
-		Handler:        defaultMux,
+		Handler:        handler,
 		ReadTimeout:    time.Duration(config.ReadTimeout) * time.Second,
 		WriteTimeout:   time.Duration(config.WriteTimeout) * time.Second,
 		MaxHeaderBytes: int(config.MaxHeaderBytes),
 	}
-	if ctx != nil {
-		go func() {
-			<-ctx.Done()
-			serv.Close()
-		}()
-	}
+	notifyShutdown(serv)
 
 	ln, err := net.Listen("tcp", serv.Addr)
 	if err != nil {
-		close(c)
 		return err
 	}
 
 	go func() {
 		defer ln.Close()
-		c <- serv.Serve(ln)
+		c <- run(ctx, serv, config, func() error {
+			return serv.Serve(ln)
+		})
 	}()
 	return nil
 }
 
-// serveTLS starts a REX server with TLS.
-func serveTLS(ctx context.Context, config *ServerConfig, c chan error) error {
+// serveTLS starts a REX server with TLS, the autocert manager is optional.
+func serveTLS(ctx context.Context, config *ServerConfig, m *autocert.Manager, c chan error) error {
 	tls := config.TLS
 	port := tls.Port
 	if port == 0 {
@@ -81,86 +132,198 @@ func serveTLS(ctx context.Context, config *ServerConfig, c chan error) error {
 	}
 	serv := &http.Server{
 		Addr:           fmt.Sprintf(("%s:%d"), config.Host, port),
-		Handler:        defaultMux,
+		Handler:        tls.HSTS.wrap(config.handler()),
 		ReadTimeout:    time.Duration(config.ReadTimeout) * time.Second,
 		WriteTimeout:   time.Duration(config.WriteTimeout) * time.Second,
 		MaxHeaderBytes: int(config.MaxHeaderBytes),
 	}
-	if tls.AutoTLS.AcceptTOS {
-		m := &autocert.Manager{
-			Prompt: autocert.AcceptTOS,
-		}
-		if tls.AutoTLS.Cache != nil {
-			m.Cache = tls.AutoTLS.Cache
-		} else if cacheDir := tls.AutoTLS.CacheDir; cacheDir != "" {
-			fi, err := os.Stat(cacheDir)
-			if err == nil && !fi.IsDir() {
-				err = fmt.Errorf("AutoTLS: invalid cache dir '%s'", cacheDir)
-				close(c)
-				return err
-			}
-			if err != nil && os.IsNotExist(err) {
-				err = os.MkdirAll(cacheDir, 0755)
-				if err != nil {
-					err = fmt.Errorf("AutoTLS: can't create the cache dir '%s'", cacheDir)
-					close(c)
-					return err
-				}
-			}
-			m.Cache = autocert.DirCache(cacheDir)
-		}
-		if len(tls.AutoTLS.Hosts) > 0 {
-			m.HostPolicy = autocert.HostWhitelist(tls.AutoTLS.Hosts...)
-		}
+	if m != nil {
 		serv.TLSConfig = m.TLSConfig()
 	}
-	if ctx != nil {
-		go func() {
-			<-ctx.Done()
-			serv.Close()
-		}()
-	}
+	notifyShutdown(serv)
 
 	ln, err := net.Listen("tcp", serv.Addr)
 	if err != nil {
-		close(c)
 		return err
 	}
 
 	go func() {
 		defer ln.Close()
-		c <- serv.ServeTLS(ln, tls.CertFile, tls.KeyFile)
+		c <- run(ctx, serv, config, func() error {
+			return serv.ServeTLS(ln, tls.CertFile, tls.KeyFile)
+		})
+	}()
+	return nil
+}
+
+// newAutocertManager creates an autocert manager if the AcceptTOS is true.
+func newAutocertManager(config AutoTLSConfig) (*autocert.Manager, error) {
+	if !config.AcceptTOS {
+		return nil, nil
+	}
+	m := &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+	}
+	if config.Cache != nil {
+		m.Cache = config.Cache
+	} else if cacheDir := config.CacheDir; cacheDir != "" {
+		fi, err := os.Stat(cacheDir)
+		if err == nil && !fi.IsDir() {
+			return nil, fmt.Errorf("AutoTLS: invalid cache dir '%s'", cacheDir)
+		}
+		if err != nil && os.IsNotExist(err) {
+			err = os.MkdirAll(cacheDir, 0755)
+			if err != nil {
+				return nil, fmt.Errorf("AutoTLS: can't create the cache dir '%s'", cacheDir)
+			}
+		}
+		m.Cache = autocert.DirCache(cacheDir)
+	}
+	if len(config.Hosts) > 0 {
+		m.HostPolicy = autocert.HostWhitelist(config.Hosts...)
+	}
+	return m, nil
+}
+
+// redirectToHTTPS returns a handler that redirects all requests to the https server.
+func redirectToHTTPS(port uint16) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if port != 0 && port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(int(port)))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		code := http.StatusMovedPermanently
+		if r.Method != "GET" && r.Method != "HEAD" {
+			// keep the request method and body
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
+
+// run runs the server until it stops, the server will be shut down gracefully
+// when the context is done. It returns nil if all in-flight requests are drained
+// cleanly.
+func run(ctx context.Context, serv *http.Server, config *ServerConfig, serveFn func() error) error {
+	if ctx == nil {
+		return serveFn()
+	}
+
+	shutdown := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			shutdown <- gracefulShutdown(serv, config.ShutdownTimeout)
+		case <-stop:
+		}
 	}()
+
+	err := serveFn()
+	if err == http.ErrServerClosed {
+		err = <-shutdown
+	}
+	return err
+}
+
+// shutdownKey is the context key of the channel that's closed when the server
+// starts shutting down.
+type shutdownKey struct{}
+
+// notifyShutdown closes the shutdown channel in the request contexts when the
+// server starts shutting down, the long-lived requests like the event streams
+// end by it since the http.Server never cancels the request contexts.
+func notifyShutdown(serv *http.Server) {
+	shutdown := make(chan struct{})
+	serv.BaseContext = func(net.Listener) context.Context {
+		return context.WithValue(context.Background(), shutdownKey{}, (<-chan struct{})(shutdown))
+	}
+	serv.RegisterOnShutdown(func() {
+		close(shutdown)
+	})
+}
+
+// serverShutdown returns the channel that's closed when the server of the
+// request starts shutting down, or nil if the server isn't started by Serve.
+func serverShutdown(ctx context.Context) <-chan struct{} {
+	shutdown, _ := ctx.Value(shutdownKey{}).(<-chan struct{})
+	return shutdown
+}
+
+// gracefulShutdown shuts down the server without interrupting any active
+// connections, the remaining connections will be closed after the timeout.
+func gracefulShutdown(serv *http.Server, timeout uint32) error {
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	err := serv.Shutdown(ctx)
+	if err != nil {
+		serv.Close()
+		return fmt.Errorf("graceful shutdown: %w", err)
+	}
 	return nil
 }
 
-// Serve serves a REX server.
+// Serve serves a REX server. It blocks until the server stops, the returned channel
+// reports nil if the server was shut down cleanly by the context.
 func Serve(ctx context.Context, config ServerConfig, onStart func(port, tlsPort uint16)) (c chan error) {
 	c = make(chan error, 1)
 
 	if tls := config.TLS; tls.AutoTLS.AcceptTOS || (tls.CertFile != "" && tls.KeyFile != "") {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		m, err := newAutocertManager(tls.AutoTLS)
+		if err != nil {
+			c <- err
+			return
+		}
+		handler := config.handler()
+		if tls.AutoRedirect {
+			handler = redirectToHTTPS(tls.Port)
+		}
+		if m != nil {
+			// handle the ACME "http-01" challenge responses
+			handler = m.HTTPHandler(handler)
+		}
 		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
-		errc := make(chan error, 1)
-		err := serve(ctx, &config, errc)
+		errc := make(chan error, 2)
+		err = serve(ctx, &config, handler, errc)
 		if err != nil {
 			c <- err
 			return
 		}
-		err = serveTLS(ctx, &config, errc)
+		err = serveTLS(ctx, &config, m, errc)
 		if err != nil {
+			cancel()
+			<-errc
 			c <- err
 			return
 		}
 		if onStart != nil {
 			onStart(config.Port, config.TLS.Port)
 		}
-		c <- <-errc
+		// stop both servers once one of them stops
+		err = <-errc
+		cancel()
+		err = errors.Join(err, <-errc)
+		config.shutdown()
+		c <- err
 		return
 	}
 
 	errc := make(chan error, 1)
-	err := serve(ctx, &config, errc)
+	err := serve(ctx, &config, config.handler(), errc)
 	if err != nil {
 		c <- err
 		return
@@ -168,22 +331,38 @@ func Serve(ctx context.Context, config ServerConfig, onStart func(port, tlsPort
 	if onStart != nil {
 		onStart(config.Port, 0)
 	}
-	c <- <-errc
+	err = <-errc
+	config.shutdown()
+	c <- err
 	return
 }
 
+// Serve serves a REX server with the mux as the handler.
+func (a *Mux) Serve(ctx context.Context, config ServerConfig, onStart func(port, tlsPort uint16)) chan error {
+	config.Handler = a
+	return Serve(ctx, config, onStart)
+}
+
 // Start starts a REX server.
 func Start(ctx context.Context, port uint16, onStart func(port uint16)) chan error {
+	return start(ctx, &ServerConfig{Port: port}, onStart)
+}
+
+// Start starts a REX server with the mux as the handler.
+func (a *Mux) Start(ctx context.Context, port uint16, onStart func(port uint16)) chan error {
+	return start(ctx, &ServerConfig{Handler: a, Port: port}, onStart)
+}
+
+// start starts a REX server without TLS.
+func start(ctx context.Context, config *ServerConfig, onStart func(port uint16)) chan error {
 	c := make(chan error, 1)
-	err := serve(ctx, &ServerConfig{
-		Port: port,
-	}, c)
+	err := serve(ctx, config, config.handler(), c)
 	if err != nil {
 		c <- err
 		return c
 	}
 	if onStart != nil {
-		onStart(port)
+		onStart(config.Port)
 	}
 	return c
 }
@@ -197,7 +376,7 @@ func StartWithTLS(ctx context.Context, port uint16, certFile string, keyFile str
 			CertFile: certFile,
 			KeyFile:  keyFile,
 		},
-	}, c)
+	}, nil, c)
 	if err != nil {
 		c <- err
 		return c
@@ -211,15 +390,19 @@ func StartWithTLS(ctx context.Context, port uint16, certFile string, keyFile str
 // StartWithAutoTLS starts a REX server with autocert powered by Let's Encrypto SSL
 func StartWithAutoTLS(ctx context.Context, port uint16, onStart func(port uint16)) chan error {
 	c := make(chan error, 1)
-	err := serveTLS(ctx, &ServerConfig{
+	m, err := newAutocertManager(AutoTLSConfig{
+		AcceptTOS: true,
+		CacheDir:  "/var/rex/autotls",
+	})
+	if err != nil {
+		c <- err
+		return c
+	}
+	err = serveTLS(ctx, &ServerConfig{
 		TLS: TLSConfig{
 			Port: port,
-			AutoTLS: AutoTLSConfig{
-				AcceptTOS: true,
-				CacheDir:  "/var/rex/autotls",
-			},
 		},
-	}, c)
+	}, m, c)
 	if err != nil {
 		c <- err
 		return c
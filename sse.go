@@ -0,0 +1,422 @@
+package rex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultHeartbeat is the default interval of the event stream heartbeats.
+const defaultHeartbeat = 15 * time.Second
+
+// ErrStreamClosed is returned when sending events to a closed event stream.
+var ErrStreamClosed = errors.New("event stream closed")
+
+// Event is a message of Server-Sent Events. The Data is sent as is if it's
+// a string or []byte, otherwise it's encoded as JSON.
+type Event struct {
+	ID    string
+	Event string
+	Data  any
+	Retry time.Duration
+}
+
+// EventStream sends Server-Sent Events to the client.
+type EventStream struct {
+	ctx       *Context
+	done      <-chan struct{}
+	lock      sync.Mutex
+	closed    bool
+	heartbeat *time.Ticker
+}
+
+type sse struct {
+	fn func(stream *EventStream) error
+}
+
+// SSE replies to the request with an event stream, the stream is closed
+// when the fn returns, the client disconnects or the server shuts down.
+func SSE(fn func(stream *EventStream) error) any {
+	return &sse{fn}
+}
+
+// LastEventID returns the Last-Event-ID header sent by the client when it reconnects.
+func (s *EventStream) LastEventID() string {
+	return s.ctx.R.Header.Get("Last-Event-ID")
+}
+
+// Context returns the Context of the request.
+func (s *EventStream) Context() *Context {
+	return s.ctx
+}
+
+// Done returns a channel that's closed when the client disconnects or the
+// server starts shutting down.
+func (s *EventStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// SetHeartbeat sets the interval of the heartbeat comments which keep the
+// connection alive, the default interval is 15 seconds. A zero interval
+// disables the heartbeats.
+func (s *EventStream) SetHeartbeat(d time.Duration) {
+	if d > 0 {
+		s.heartbeat.Reset(d)
+	} else {
+		s.heartbeat.Stop()
+	}
+}
+
+// Send sends an event to the client.
+func (s *EventStream) Send(event Event) error {
+	buf := bytes.NewBuffer(nil)
+	if event.ID != "" {
+		buf.WriteString("id: ")
+		buf.WriteString(sseField(event.ID))
+		buf.WriteByte('\n')
+	}
+	if event.Event != "" {
+		buf.WriteString("event: ")
+		buf.WriteString(sseField(event.Event))
+		buf.WriteByte('\n')
+	}
+	if event.Retry > 0 {
+		buf.WriteString("retry: ")
+		buf.WriteString(strconv.FormatInt(event.Retry.Milliseconds(), 10))
+		buf.WriteByte('\n')
+	}
+	var data string
+	switch v := event.Data.(type) {
+	case nil:
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		p, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		data = string(p)
+	}
+	if data != "" || (event.ID == "" && event.Event == "" && event.Retry == 0) {
+		data = strings.ReplaceAll(data, "\r\n", "\n")
+		for _, line := range strings.Split(strings.ReplaceAll(data, "\r", "\n"), "\n") {
+			buf.WriteString("data: ")
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+	buf.WriteByte('\n')
+	return s.write(buf.Bytes())
+}
+
+// Comment sends a comment to the client, which is ignored by the EventSource.
+func (s *EventStream) Comment(text string) error {
+	buf := bytes.NewBuffer(nil)
+	for _, line := range strings.Split(sseField(text), "\n") {
+		buf.WriteString(": ")
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return s.write(buf.Bytes())
+}
+
+func (s *EventStream) write(p []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return ErrStreamClosed
+	}
+	select {
+	case <-s.done:
+		s.closed = true
+		return ErrStreamClosed
+	default:
+	}
+	_, err := s.ctx.W.Write(p)
+	if err != nil {
+		s.closed = true
+		return err
+	}
+	if f, ok := s.ctx.W.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (s *EventStream) close() {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+}
+
+// serve sends the response header and runs the fn with the stream.
+func (r *sse) serve(ctx *Context) {
+	w := ctx.W
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	if ctx.R.ProtoMajor == 1 {
+		h.Set("Connection", "keep-alive")
+	}
+	// the stream should never be buffered by the compression writer
+	ctx.compress = nil
+	// a long-lived stream outlives the server write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.WriteHeader(200)
+
+	// the stream ends when the server starts shutting down, or the server
+	// would wait for it until the shutdown timeout
+	c, cancel := context.WithCancel(ctx.R.Context())
+	defer cancel()
+	if shutdown := serverShutdown(c); shutdown != nil {
+		go func() {
+			select {
+			case <-shutdown:
+				cancel()
+			case <-c.Done():
+			}
+		}()
+	}
+	ctx.R = ctx.R.WithContext(c)
+
+	stream := &EventStream{
+		ctx:       ctx,
+		done:      c.Done(),
+		heartbeat: time.NewTicker(defaultHeartbeat),
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-stream.done:
+				return
+			case <-stream.heartbeat.C:
+				stream.write([]byte(": heartbeat\n\n"))
+			}
+		}
+	}()
+
+	err := r.fn(stream)
+	close(stop)
+	wg.Wait()
+	stream.heartbeat.Stop()
+	stream.close()
+	if err != nil && err != ErrStreamClosed && !errors.Is(err, context.Canceled) {
+		ctx.logf("error", "%s", err.Error())
+	}
+}
+
+// sseField removes the line breaks which are not allowed in the field value.
+func sseField(s string) string {
+	if strings.ContainsAny(s, "\r\n\x00") {
+		s = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ", "\x00", "").Replace(s)
+	}
+	return s
+}
+
+// ErrSlowSubscriber is returned by the SSEHub.Subscribe if the subscriber
+// can't keep up with the published events.
+var ErrSlowSubscriber = errors.New("event stream subscriber is too slow")
+
+// SSEHub publishes events to the event streams subscribed to the topics.
+// The hub keeps the recent events of each topic to replay them to the
+// clients reconnecting with the Last-Event-ID header.
+type SSEHub struct {
+	lock        sync.Mutex
+	topics      map[string]*sseTopic
+	historySize int
+	bufferSize  int
+	seq         uint64
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+type sseTopic struct {
+	subscribers map[*sseSubscriber]struct{}
+	history     []sseHubEvent
+}
+
+type sseHubEvent struct {
+	seq   uint64
+	event Event
+}
+
+type sseSubscriber struct {
+	events   chan sseHubEvent
+	dropped  chan struct{}
+	dropOnce sync.Once
+}
+
+// NewSSEHub returns a new SSEHub that keeps the recent historySize events of each topic.
+func NewSSEHub(historySize int) *SSEHub {
+	if historySize < 0 {
+		historySize = 0
+	}
+	return &SSEHub{
+		topics:      map[string]*sseTopic{},
+		historySize: historySize,
+		bufferSize:  64,
+		done:        make(chan struct{}),
+	}
+}
+
+// Publish publishes an event to the topic. The event ID is generated by the
+// hub if it's empty. Subscribers that can't keep up are dropped.
+func (hub *SSEHub) Publish(topic string, event Event) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	hub.seq++
+	if event.ID == "" {
+		event.ID = strconv.FormatUint(hub.seq, 10)
+	}
+	e := sseHubEvent{hub.seq, event}
+	t, ok := hub.topics[topic]
+	if !ok {
+		if hub.historySize == 0 {
+			return
+		}
+		t = hub.topic(topic)
+	}
+	if hub.historySize > 0 {
+		if len(t.history) >= hub.historySize {
+			copy(t.history, t.history[1:])
+			t.history = t.history[:len(t.history)-1]
+		}
+		t.history = append(t.history, e)
+	}
+	for sub := range t.subscribers {
+		select {
+		case sub.events <- e:
+		default:
+			delete(t.subscribers, sub)
+			sub.dropOnce.Do(func() {
+				close(sub.dropped)
+			})
+		}
+	}
+}
+
+// Subscribers returns the number of the subscribers of the topic.
+func (hub *SSEHub) Subscribers(topic string) int {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	if t, ok := hub.topics[topic]; ok {
+		return len(t.subscribers)
+	}
+	return 0
+}
+
+// Subscribe sends the events published to the topics to the stream until the
+// client disconnects or the hub is closed. The events after the Last-Event-ID
+// are replayed first, all the kept events are replayed if the Last-Event-ID is
+// not found in the history.
+func (hub *SSEHub) Subscribe(stream *EventStream, topics ...string) error {
+	sub := &sseSubscriber{
+		events:  make(chan sseHubEvent, hub.bufferSize),
+		dropped: make(chan struct{}),
+	}
+	replay := hub.subscribe(sub, topics, stream.LastEventID())
+	defer hub.unsubscribe(sub, topics)
+
+	for _, e := range replay {
+		if err := stream.Send(e.event); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case e := <-sub.events:
+			if err := stream.Send(e.event); err != nil {
+				return err
+			}
+		case <-sub.dropped:
+			return ErrSlowSubscriber
+		case <-stream.Done():
+			return nil
+		case <-hub.done:
+			return nil
+		}
+	}
+}
+
+// Close closes the hub, all the subscriptions end.
+func (hub *SSEHub) Close() {
+	hub.closeOnce.Do(func() {
+		close(hub.done)
+	})
+}
+
+// subscribe registers the subscriber and returns the events to replay.
+func (hub *SSEHub) subscribe(sub *sseSubscriber, topics []string, lastEventID string) []sseHubEvent {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	var replay []sseHubEvent
+	for _, name := range topics {
+		t := hub.topic(name)
+		t.subscribers[sub] = struct{}{}
+		if lastEventID != "" {
+			replay = append(replay, t.history...)
+		}
+	}
+	if len(replay) == 0 {
+		return nil
+	}
+	sort.Slice(replay, func(i, j int) bool {
+		return replay[i].seq < replay[j].seq
+	})
+	for i, e := range replay {
+		if e.event.ID == lastEventID {
+			return replay[i+1:]
+		}
+	}
+	return replay
+}
+
+func (hub *SSEHub) unsubscribe(sub *sseSubscriber, topics []string) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	for _, name := range topics {
+		if t, ok := hub.topics[name]; ok {
+			delete(t.subscribers, sub)
+			if len(t.subscribers) == 0 && len(t.history) == 0 {
+				delete(hub.topics, name)
+			}
+		}
+	}
+}
+
+func (hub *SSEHub) topic(name string) *sseTopic {
+	t, ok := hub.topics[name]
+	if !ok {
+		t = &sseTopic{subscribers: map[*sseSubscriber]struct{}{}}
+		hub.topics[name] = t
+	}
+	return t
+}
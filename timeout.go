@@ -0,0 +1,193 @@
+package rex
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Timeout returns a middleware that cancels the request context after the
+// duration d. The rest handles run in a separate goroutine, if they haven't
+// written the response header by the deadline, the request is replied with
+// 503 through the error handler and the late writes are discarded. The request
+// is replied with 404 if none of the rest handles replies it.
+func Timeout(d time.Duration) Handle {
+	return func(ctx *Context) any {
+		c, cancel := context.WithTimeout(ctx.R.Context(), d)
+		defer cancel()
+
+		tw := &timeoutWriter{w: ctx.W, header: ctx.W.Header().Clone()}
+		hw := &rexWriter{rawWriter: tw, code: 200}
+
+		// the rest handles run on a copy of the context
+		hctx := new(Context)
+		*hctx = *ctx
+		hctx.R = ctx.R.WithContext(c)
+		hctx.W = hw
+		hctx.writer = hw
+		hctx.header = tw.header
+		hctx.onFinish = slices.Clip(ctx.onFinish)
+		hw.ctx = hctx
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer hw.Close()
+			defer func() {
+				if v := recover(); v != nil {
+					hctx.recoverPanic(v)
+				}
+			}()
+			var v any = next
+			if resume := hctx.rest.resume(hctx); resume != nil {
+				v = resume()
+			}
+			if v == next {
+				// the rest handles have been executed, don't run them again
+				v = &invalid{404, "Not Found"}
+			}
+			if v != nil {
+				hctx.respondWith(v)
+			}
+		}()
+
+		select {
+		case <-done:
+		case <-c.Done():
+			tw.lock.Lock()
+			if !tw.wroteHeader {
+				tw.timedOut = true
+				tw.lock.Unlock()
+				return &invalid{503, "Service Unavailable"}
+			}
+			tw.lock.Unlock()
+			// the response is being written, wait for the handles to return
+			<-done
+		}
+
+		tw.lock.Lock()
+		if !tw.wroteHeader {
+			tw.writeHeader()
+		}
+		tw.lock.Unlock()
+
+		// take the changes of the handles, e.g. the session and the finish hooks,
+		// the request keeps the matched route but not the timeout context that's
+		// canceled on return
+		ctx.R = hctx.R.WithContext(ctx.R.Context())
+		ctx.basicAuthUser = hctx.basicAuthUser
+		ctx.aclUser = hctx.aclUser
+		ctx.session = hctx.session
+		ctx.sessionPool = hctx.sessionPool
+		ctx.sessionIdHandler = hctx.sessionIdHandler
+		ctx.logger = hctx.logger
+		ctx.accessLog = hctx.accessLog
+		ctx.handlingError = hctx.handlingError
+		ctx.onFinish = hctx.onFinish
+		ctx.requestID = hctx.requestID
+		ctx.span = hctx.span
+		return nil
+	}
+}
+
+// timeoutWriter buffers the header of the handles until the response is written,
+// the writes after the timeout are discarded.
+type timeoutWriter struct {
+	lock        sync.Mutex
+	w           http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+	timedOut    bool
+}
+
+// Header returns the header of the handles.
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// WriteHeader sends the header with the status code unless the request is timed out.
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.writeHeader()
+	tw.w.WriteHeader(code)
+}
+
+// Write writes the data unless the request is timed out.
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader()
+	}
+	return tw.w.Write(p)
+}
+
+// Flush sends any buffered data to the client.
+func (tw *timeoutWriter) Flush() {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if tw.timedOut {
+		return
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader()
+	}
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection unless the request is timed out.
+func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if tw.timedOut {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	h, ok := tw.w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the raw response writer does not implement the http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		tw.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying response writer, it's used by the http.ResponseController.
+// It returns nil after the timeout, the response writer may be reused by then.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if tw.timedOut {
+		return nil
+	}
+	return tw.w
+}
+
+// writeHeader copies the header of the handles to the underlying response writer.
+func (tw *timeoutWriter) writeHeader() {
+	h := tw.w.Header()
+	for k := range h {
+		if _, ok := tw.header[k]; !ok {
+			delete(h, k)
+		}
+	}
+	for k, v := range tw.header {
+		h[k] = v
+	}
+	tw.wroteHeader = true
+}
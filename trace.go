@@ -0,0 +1,432 @@
+package rex
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	mathrand "math/rand/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span is a server span of a request.
+type Span struct {
+	Name         string
+	TraceID      [16]byte
+	SpanID       [8]byte
+	ParentSpanID [8]byte
+	TraceState   string
+	Sampled      bool
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   map[string]any
+	// Error is true if the request failed with a server error.
+	Error bool
+}
+
+// TraceParent returns the traceparent header value of the span.
+func (s *Span) TraceParent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(s.TraceID[:]) + "-" + hex.EncodeToString(s.SpanID[:]) + "-" + flags
+}
+
+// Inject sets the traceparent and tracestate headers to propagate the trace
+// context to the outgoing request.
+func (s *Span) Inject(header http.Header) {
+	header.Set("traceparent", s.TraceParent())
+	if s.TraceState != "" {
+		header.Set("tracestate", s.TraceState)
+	} else {
+		header.Del("tracestate")
+	}
+}
+
+// SetAttribute sets an attribute of the span, the value should be a string,
+// bool, integer or float.
+func (s *Span) SetAttribute(key string, value any) {
+	if s.Attributes == nil {
+		s.Attributes = map[string]any{}
+	}
+	s.Attributes[key] = value
+}
+
+// SpanExporter exports the finished spans, the ExportSpan method must not block.
+type SpanExporter interface {
+	ExportSpan(span *Span)
+}
+
+// TraceOptions contains the options for the Trace middleware.
+type TraceOptions struct {
+	// Exporter exports the sampled spans.
+	Exporter SpanExporter
+	// SampleRate is the fraction of the new traces to sample, in the range [0, 1].
+	// All new traces are sampled if it's zero, the sampled flag of the incoming
+	// traceparent is respected.
+	SampleRate float64
+	// TraceResponse sends the traceresponse header defined in W3C Trace Context
+	// Level 2 with the trace ID and the span ID of the request, the IDs are not
+	// exposed to the clients by default.
+	TraceResponse bool
+}
+
+// Trace returns a middleware that propagates the W3C trace context and records
+// a server span for each request, the span is named by the route pattern.
+func Trace(opts TraceOptions) Handle {
+	return func(ctx *Context) any {
+		r := ctx.R
+		span := &Span{StartTime: time.Now()}
+		if traceID, parentID, sampled, ok := parseTraceParent(r.Header.Get("traceparent")); ok {
+			span.TraceID = traceID
+			span.ParentSpanID = parentID
+			span.Sampled = sampled
+			if ts := strings.TrimSpace(strings.Join(r.Header.Values("tracestate"), ",")); len(ts) <= 512 {
+				span.TraceState = ts
+			}
+		} else {
+			rand.Read(span.TraceID[:])
+			span.Sampled = opts.SampleRate <= 0 || opts.SampleRate >= 1 || mathrand.Float64() < opts.SampleRate
+		}
+		rand.Read(span.SpanID[:])
+		ctx.span = span
+		if opts.TraceResponse {
+			ctx.header.Set("traceresponse", span.TraceParent())
+		}
+
+		ctx.OnFinish(func(status int, written int64) {
+			span.EndTime = time.Now()
+			r := ctx.R
+			route := ctx.RoutePattern()
+			span.Name = r.Method
+			if route != "" {
+				_, host, path := parsePattern(route)
+				span.Name += " " + host + path
+				span.SetAttribute("http.route", host+path)
+			}
+			span.SetAttribute("http.request.method", r.Method)
+			span.SetAttribute("http.response.status_code", status)
+			span.SetAttribute("http.response.body.size", written)
+			span.SetAttribute("url.path", r.URL.Path)
+			span.SetAttribute("url.scheme", ctx.Scheme())
+			span.SetAttribute("server.address", ctx.Host())
+			span.SetAttribute("client.address", ctx.RemoteIP())
+			if ua := r.UserAgent(); ua != "" {
+				span.SetAttribute("user_agent.original", ua)
+			}
+			span.Error = status >= 500
+			if span.Sampled && opts.Exporter != nil {
+				opts.Exporter.ExportSpan(span)
+			}
+		})
+		return next
+	}
+}
+
+// parseTraceParent parses the traceparent header defined in W3C Trace Context.
+func parseTraceParent(s string) (traceID [16]byte, parentID [8]byte, sampled bool, ok bool) {
+	s = strings.TrimSpace(s)
+	// version-traceid-parentid-flags, the future versions may append fields
+	if len(s) < 55 || (len(s) > 55 && s[55] != '-') || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return
+	}
+	version := s[0:2]
+	if !isLowerHex(version) || version == "ff" || (version == "00" && len(s) != 55) {
+		return
+	}
+	if !isLowerHex(s[3:35]) || !isLowerHex(s[36:52]) || !isLowerHex(s[53:55]) {
+		return
+	}
+	hex.Decode(traceID[:], []byte(s[3:35]))
+	hex.Decode(parentID[:], []byte(s[36:52]))
+	if traceID == [16]byte{} || parentID == [8]byte{} {
+		return
+	}
+	flags, _ := strconv.ParseUint(s[53:55], 16, 8)
+	return traceID, parentID, flags&1 == 1, true
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// InMemoryExporter keeps the exported spans in memory, it's useful for tests.
+type InMemoryExporter struct {
+	lock  sync.Mutex
+	spans []*Span
+}
+
+// ExportSpan implements the SpanExporter interface.
+func (e *InMemoryExporter) ExportSpan(span *Span) {
+	e.lock.Lock()
+	e.spans = append(e.spans, span)
+	e.lock.Unlock()
+}
+
+// Spans returns the exported spans.
+func (e *InMemoryExporter) Spans() []*Span {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+// Reset removes the exported spans.
+func (e *InMemoryExporter) Reset() {
+	e.lock.Lock()
+	e.spans = nil
+	e.lock.Unlock()
+}
+
+// OTLPExporterOptions contains the options for the OTLPExporter.
+type OTLPExporterOptions struct {
+	// Endpoint is the URL of the OTLP/HTTP traces endpoint,
+	// default is "http://localhost:4318/v1/traces".
+	Endpoint string
+	// Headers are sent with the export requests, e.g. the authorization header.
+	Headers map[string]string
+	// ServiceName is the service.name resource attribute.
+	ServiceName string
+	// BatchSize is the max number of spans of an export request, default is 512.
+	BatchSize int
+	// FlushInterval is the interval to export the queued spans, default is 5 seconds.
+	FlushInterval time.Duration
+	// Client is the http client to send the export requests.
+	Client *http.Client
+}
+
+// OTLPExporter exports the spans to an OpenTelemetry collector by the OTLP/HTTP
+// protocol in JSON encoding, the spans are exported in batches in background.
+// The spans are dropped if the queue is full.
+type OTLPExporter struct {
+	opts    OTLPExporterOptions
+	queue   chan *Span
+	flush   chan chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	onError func(err error)
+}
+
+// NewOTLPExporter returns a new OTLPExporter.
+func NewOTLPExporter(opts OTLPExporterOptions) *OTLPExporter {
+	if opts.Endpoint == "" {
+		opts.Endpoint = "http://localhost:4318/v1/traces"
+	}
+	if opts.ServiceName == "" {
+		opts.ServiceName = "rex"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 512
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	e := &OTLPExporter{
+		opts:    opts,
+		queue:   make(chan *Span, opts.BatchSize*4),
+		flush:   make(chan chan struct{}),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go e.loop()
+	return e
+}
+
+// OnError sets the handler of the export errors.
+func (e *OTLPExporter) OnError(fn func(err error)) {
+	e.onError = fn
+}
+
+// ExportSpan implements the SpanExporter interface.
+func (e *OTLPExporter) ExportSpan(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+		// drop the span if the queue is full
+	}
+}
+
+// Flush exports the queued spans.
+func (e *OTLPExporter) Flush() {
+	c := make(chan struct{})
+	select {
+	case e.flush <- c:
+		<-c
+	case <-e.stopped:
+	}
+}
+
+// Shutdown exports the queued spans and stops the exporter.
+func (e *OTLPExporter) Shutdown() {
+	e.once.Do(func() {
+		close(e.done)
+	})
+	<-e.stopped
+}
+
+func (e *OTLPExporter) loop() {
+	defer close(e.stopped)
+
+	ticker := time.NewTicker(e.opts.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	export := func() {
+		for len(batch) > 0 {
+			n := min(len(batch), e.opts.BatchSize)
+			if err := e.export(batch[:n]); err != nil && e.onError != nil {
+				e.onError(err)
+			}
+			batch = batch[n:]
+		}
+		batch = nil
+	}
+	drain := func() {
+		for {
+			select {
+			case span := <-e.queue:
+				batch = append(batch, span)
+			default:
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= e.opts.BatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case c := <-e.flush:
+			drain()
+			export()
+			close(c)
+		case <-e.done:
+			drain()
+			export()
+			return
+		}
+	}
+}
+
+// export sends the spans to the collector.
+func (e *OTLPExporter) export(spans []*Span) error {
+	body, err := json.Marshal(encodeOTLPSpans(e.opts.ServiceName, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(context.Background(), "POST", e.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.opts.Headers {
+		req.Header.Set(k, v)
+	}
+	res, err := e.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode >= 300 {
+		return errors.New("otlp export: " + res.Status)
+	}
+	return nil
+}
+
+// encodeOTLPSpans encodes the spans in the OTLP JSON format.
+func encodeOTLPSpans(serviceName string, spans []*Span) map[string]any {
+	list := make([]map[string]any, len(spans))
+	for i, s := range spans {
+		span := map[string]any{
+			"traceId":           hex.EncodeToString(s.TraceID[:]),
+			"spanId":            hex.EncodeToString(s.SpanID[:]),
+			"name":              s.Name,
+			"kind":              2, // SPAN_KIND_SERVER
+			"startTimeUnixNano": strconv.FormatInt(s.StartTime.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.Attributes),
+		}
+		if s.ParentSpanID != [8]byte{} {
+			span["parentSpanId"] = hex.EncodeToString(s.ParentSpanID[:])
+		}
+		if s.TraceState != "" {
+			span["traceState"] = s.TraceState
+		}
+		if s.Error {
+			span["status"] = map[string]any{"code": 2} // STATUS_CODE_ERROR
+		}
+		list[i] = span
+	}
+	return map[string]any{
+		"resourceSpans": []any{
+			map[string]any{
+				"resource": map[string]any{
+					"attributes": otlpAttributes(map[string]any{"service.name": serviceName}),
+				},
+				"scopeSpans": []any{
+					map[string]any{
+						"scope": map[string]any{"name": "github.com/ije/rex"},
+						"spans": list,
+					},
+				},
+			},
+		},
+	}
+}
+
+// otlpAttributes encodes the attributes in the OTLP JSON format.
+func otlpAttributes(attrs map[string]any) []any {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := make([]any, 0, len(keys))
+	for _, k := range keys {
+		var value map[string]any
+		switch v := attrs[k].(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		list = append(list, map[string]any{"key": k, "value": value})
+	}
+	return list
+}
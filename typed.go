@@ -0,0 +1,105 @@
+package rex
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Typed returns a Handle that binds the input from the request and replies
+// with the JSON encoding of the output.
+//
+// If In is a struct (or a pointer to a struct), it's bound by [Context.Bind]
+// from the path, query, headers and body; other types are decoded from the
+// JSON body. A returned *Error replies with its status code, other errors are
+// handled as internal server errors.
+//
+// The In/Out types are recorded and can be looked up by [HandleTypes].
+func Typed[In, Out any](fn func(ctx *Context, in In) (Out, error)) Handle {
+	inType := reflect.TypeFor[In]()
+	isPtr := inType.Kind() == reflect.Pointer
+	elemType := inType
+	if isPtr {
+		elemType = inType.Elem()
+	}
+	isStruct := elemType.Kind() == reflect.Struct
+	bindable := !isStruct || elemType.NumField() > 0
+
+	t := &typedHandle{in: inType, out: reflect.TypeFor[Out]()}
+	t.handle = func(ctx *Context) any {
+		var in In
+		if bindable {
+			ptr := reflect.New(elemType)
+			var err error
+			if isStruct {
+				err = ctx.Bind(ptr.Interface())
+			} else {
+				_, err = ctx.decodeBody(ptr.Interface())
+			}
+			if err != nil {
+				return err
+			}
+			if isPtr {
+				in = ptr.Interface().(In)
+			} else {
+				reflect.ValueOf(&in).Elem().Set(ptr.Elem())
+			}
+		}
+		out, err := fn(ctx, in)
+		if err != nil {
+			var e *Error
+			if errors.As(err, &e) {
+				return e
+			}
+			return err
+		}
+		return &jsonValue{out}
+	}
+	return t.serve
+}
+
+// typeProbe receives the input and output types of the handle.
+type typeProbe struct {
+	in  reflect.Type
+	out reflect.Type
+}
+
+// typedHandle is the handle created by Typed.
+type typedHandle struct {
+	in     reflect.Type
+	out    reflect.Type
+	handle Handle
+}
+
+// serve runs the handle, or reports the types if the context is a probe.
+func (t *typedHandle) serve(ctx *Context) any {
+	if ctx.probe != nil {
+		ctx.probe.in, ctx.probe.out = t.in, t.out
+		return nil
+	}
+	return t.handle(ctx)
+}
+
+// typedHandleCode and chainCode are the code pointers of the handles created
+// by Typed and Chain, the method value wrappers are shared by all of them.
+var typedHandleCode, chainCode uintptr
+
+func init() {
+	typedHandleCode = reflect.ValueOf((*typedHandle)(nil).serve).Pointer()
+	chainCode = reflect.ValueOf(chain(nil).serve).Pointer()
+}
+
+// HandleTypes returns the input and output types of the handle created by Typed,
+// or of the last typed handle in the Chain.
+func HandleTypes(h Handle) (in reflect.Type, out reflect.Type, ok bool) {
+	if h == nil {
+		return nil, nil, false
+	}
+	switch reflect.ValueOf(h).Pointer() {
+	case typedHandleCode, chainCode:
+		// the handle reports its types to the probe without handling a request
+		probe := &typeProbe{}
+		h(&Context{probe: probe})
+		return probe.in, probe.out, probe.in != nil || probe.out != nil
+	}
+	return nil, nil, false
+}
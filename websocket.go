@@ -0,0 +1,773 @@
+package rex
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The message types defined in RFC 6455.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// The close codes defined in RFC 6455.
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+)
+
+const (
+	wsGUID          = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsFrameSize     = 16 << 10
+	wsCloseTimeout  = time.Second
+	wsDeflateTail   = "\x00\x00\xff\xff"
+	wsMaxControlLen = 125
+)
+
+// ErrWSClosed is returned when writing to a closed WebSocket connection.
+var ErrWSClosed = errors.New("websocket: connection closed")
+
+// CloseError is returned by the WSConn.ReadMessage when the peer closes the connection.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	if e.Text != "" {
+		return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+	}
+	return fmt.Sprintf("websocket: close %d", e.Code)
+}
+
+// WebSocketOptions contains the options to upgrade the request to WebSocket.
+type WebSocketOptions struct {
+	// Cors checks the Origin header with the allowed origins of the options,
+	// the Origin must match the request host if it's nil.
+	Cors *CorsOptions
+	// Subprotocols are the supported subprotocols in the order of preference.
+	Subprotocols []string
+	// Compress enables the permessage-deflate extension if the client supports it.
+	Compress bool
+	// ReadLimit is the maximum size of a message in bytes, default is 32MB.
+	ReadLimit int64
+}
+
+type webSocket struct {
+	fn   func(conn *WSConn) error
+	opts WebSocketOptions
+}
+
+// WebSocket upgrades the request to a WebSocket connection and runs the fn with
+// the connection, the connection is closed when the fn returns.
+func WebSocket(fn func(conn *WSConn) error, opts ...WebSocketOptions) any {
+	ws := &webSocket{fn: fn}
+	if len(opts) > 0 {
+		ws.opts = opts[0]
+	}
+	return ws
+}
+
+// WSConn is a WebSocket connection.
+type WSConn struct {
+	conn          net.Conn
+	br            *bufio.Reader
+	request       *http.Request
+	subprotocol   string
+	compress      bool
+	readLimit     int64
+	readErr       error
+	closeReceived bool
+	onPing        func(data []byte)
+	onPong        func(data []byte)
+	writeLock     sync.Mutex
+	closeSent     bool
+}
+
+// Request returns the upgrade request.
+func (c *WSConn) Request() *http.Request {
+	return c.request
+}
+
+// Subprotocol returns the negotiated subprotocol.
+func (c *WSConn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr returns the remote network address.
+func (c *WSConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadLimit sets the maximum size of a message in bytes.
+func (c *WSConn) SetReadLimit(n int64) {
+	c.readLimit = n
+}
+
+// SetReadDeadline sets the read deadline of the underlying connection.
+func (c *WSConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying connection.
+func (c *WSConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// OnPing sets the handler called when a ping frame is received, the pong
+// frame is sent automatically.
+func (c *WSConn) OnPing(fn func(data []byte)) {
+	c.onPing = fn
+}
+
+// OnPong sets the handler called when a pong frame is received.
+func (c *WSConn) OnPong(fn func(data []byte)) {
+	c.onPong = fn
+}
+
+// ReadMessage reads the next data message, the control frames are handled
+// while reading. A *CloseError is returned if the peer closes the connection.
+func (c *WSConn) ReadMessage() (messageType int, data []byte, err error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	messageType, data, err = c.readMessage()
+	if err != nil {
+		c.readErr = err
+	}
+	return
+}
+
+// ReadJSON reads the next message and decodes it as JSON.
+func (c *WSConn) ReadJSON(v any) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteMessage writes a message, the control messages are sent in a single frame.
+func (c *WSConn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case TextMessage, BinaryMessage:
+		w, err := c.NextWriter(messageType)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(data); err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	case PingMessage, PongMessage:
+		if len(data) > wsMaxControlLen {
+			return errors.New("websocket: control frame too long")
+		}
+		c.writeLock.Lock()
+		defer c.writeLock.Unlock()
+		return c.writeFrame(messageType, true, false, data)
+	case CloseMessage:
+		return errors.New("websocket: use Close to close the connection")
+	}
+	return fmt.Errorf("websocket: invalid message type %d", messageType)
+}
+
+// WriteJSON writes the JSON encoding of v as a text message.
+func (c *WSConn) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(TextMessage, data)
+}
+
+// Ping sends a ping frame.
+func (c *WSConn) Ping(data []byte) error {
+	return c.WriteMessage(PingMessage, data)
+}
+
+// NextWriter returns a writer for the next message, the message is sent in
+// fragments if it's large. Other writes are blocked until the writer is closed.
+func (c *WSConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return nil, fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	c.writeLock.Lock()
+	if c.closeSent {
+		c.writeLock.Unlock()
+		return nil, ErrWSClosed
+	}
+	w := &wsMessageWriter{c: c, opcode: messageType, compress: c.compress}
+	if c.compress {
+		w.fw = flateWriterPool.Get().(*flate.Writer)
+		w.fw.Reset(wsWriterFunc(w.write))
+	}
+	return w, nil
+}
+
+// Close sends a close frame with the code and reason, then closes the connection.
+func (c *WSConn) Close(code int, reason string) error {
+	err := c.sendClose(code, reason)
+	c.conn.Close()
+	if err == ErrWSClosed {
+		return nil
+	}
+	return err
+}
+
+// sendClose sends a close frame if it's not sent.
+func (c *WSConn) sendClose(code int, reason string) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if c.closeSent {
+		return ErrWSClosed
+	}
+	c.closeSent = true
+	var payload []byte
+	if code != CloseNoStatusReceived {
+		payload = make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		payload = append(payload, reason...)
+		if len(payload) > wsMaxControlLen {
+			payload = payload[:wsMaxControlLen]
+		}
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(wsCloseTimeout))
+	return c.writeFrame(CloseMessage, true, false, payload)
+}
+
+// finish closes the connection after the handler returns, it waits for the
+// close frame of the peer to complete the closing handshake.
+func (c *WSConn) finish(code int, reason string) {
+	defer c.conn.Close()
+
+	if c.sendClose(code, reason) != nil || c.closeReceived || c.readErr != nil {
+		return
+	}
+	c.conn.SetReadDeadline(time.Now().Add(wsCloseTimeout))
+	for {
+		if _, _, err := c.readMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// fail closes the connection with the code because of the protocol violation.
+func (c *WSConn) fail(code int, text string) error {
+	c.sendClose(code, text)
+	c.conn.Close()
+	return &wsProtocolError{code, text}
+}
+
+// wsProtocolError is returned when the peer violates the protocol.
+type wsProtocolError struct {
+	code int
+	text string
+}
+
+func (e *wsProtocolError) Error() string {
+	return "websocket: " + e.text
+}
+
+func (c *WSConn) readMessage() (int, []byte, error) {
+	var (
+		messageType int
+		compressed  bool
+		data        []byte
+	)
+	for {
+		fin, rsv1, opcode, payload, err := c.readFrame(c.readLimit - int64(len(data)))
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case PingMessage:
+			if c.onPing != nil {
+				c.onPing(payload)
+			}
+			c.writeLock.Lock()
+			if !c.closeSent {
+				err = c.writeFrame(PongMessage, true, false, payload)
+			}
+			c.writeLock.Unlock()
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			if c.onPong != nil {
+				c.onPong(payload)
+			}
+			continue
+		case CloseMessage:
+			return 0, nil, c.handleClose(payload)
+		case 0:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "expected continuation frame")
+			}
+			messageType = opcode
+			compressed = rsv1
+		}
+		data = append(data, payload...)
+		if fin {
+			break
+		}
+	}
+	if compressed {
+		var err error
+		data, err = c.inflate(data)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	if messageType == TextMessage && !utf8.Valid(data) {
+		return 0, nil, c.fail(CloseInvalidFramePayloadData, "invalid UTF-8 in text message")
+	}
+	return messageType, data, nil
+}
+
+// readFrame reads a frame of the client, the payload is unmasked.
+func (c *WSConn) readFrame(limit int64) (fin bool, rsv1 bool, opcode int, payload []byte, err error) {
+	var h [8]byte
+	if _, err = io.ReadFull(c.br, h[:2]); err != nil {
+		return
+	}
+	fin = h[0]&0x80 != 0
+	rsv1 = h[0]&0x40 != 0
+	opcode = int(h[0] & 0x0f)
+	masked := h[1]&0x80 != 0
+	size := int64(h[1] & 0x7f)
+	isControl := opcode >= CloseMessage
+
+	switch {
+	case h[0]&0x30 != 0:
+		err = c.fail(CloseProtocolError, "unexpected reserved bits")
+	case rsv1 && (!c.compress || isControl || opcode == 0):
+		err = c.fail(CloseProtocolError, "unexpected reserved bits")
+	case opcode > BinaryMessage && opcode < CloseMessage, opcode > PongMessage:
+		err = c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode))
+	case !masked:
+		err = c.fail(CloseProtocolError, "unmasked client frame")
+	case isControl && (size > wsMaxControlLen || !fin):
+		err = c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if err != nil {
+		return
+	}
+
+	switch size {
+	case 126:
+		if _, err = io.ReadFull(c.br, h[:2]); err != nil {
+			return
+		}
+		size = int64(binary.BigEndian.Uint16(h[:2]))
+	case 127:
+		if _, err = io.ReadFull(c.br, h[:8]); err != nil {
+			return
+		}
+		size = int64(binary.BigEndian.Uint64(h[:8]))
+		if size < 0 {
+			err = c.fail(CloseProtocolError, "invalid payload length")
+			return
+		}
+	}
+	if !isControl && size > limit {
+		err = c.fail(CloseMessageTooBig, "message too big")
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i&3]
+	}
+	return
+}
+
+// handleClose replies the close frame of the peer and closes the connection.
+func (c *WSConn) handleClose(payload []byte) error {
+	code := CloseNoStatusReceived
+	text := ""
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "invalid close frame")
+	case len(payload) >= 2:
+		code = int(binary.BigEndian.Uint16(payload))
+		if !validCloseCode(code) {
+			return c.fail(CloseProtocolError, fmt.Sprintf("invalid close code %d", code))
+		}
+		if !utf8.Valid(payload[2:]) {
+			return c.fail(CloseInvalidFramePayloadData, "invalid UTF-8 in close frame")
+		}
+		text = string(payload[2:])
+	}
+	c.closeReceived = true
+	c.sendClose(code, "")
+	c.conn.Close()
+	return &CloseError{Code: code, Text: text}
+}
+
+// inflate decompresses the message payload of the permessage-deflate extension.
+func (c *WSConn) inflate(data []byte) ([]byte, error) {
+	fr := flateReaderPool.Get().(io.ReadCloser)
+	defer flateReaderPool.Put(fr)
+	// the final empty block avoids the unexpected EOF error
+	fr.(flate.Resetter).Reset(io.MultiReader(bytes.NewReader(data), strings.NewReader(wsDeflateTail+"\x01\x00\x00\xff\xff")), nil)
+	out, err := io.ReadAll(io.LimitReader(fr, c.readLimit+1))
+	if err != nil {
+		return nil, c.fail(CloseInvalidFramePayloadData, "invalid compressed data")
+	}
+	if int64(len(out)) > c.readLimit {
+		return nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+	return out, nil
+}
+
+// writeFrame writes a server frame, the caller must hold the write lock.
+func (c *WSConn) writeFrame(opcode int, fin bool, rsv1 bool, payload []byte) error {
+	if c.closeSent && opcode != CloseMessage {
+		return ErrWSClosed
+	}
+	var h [10]byte
+	h[0] = byte(opcode)
+	if fin {
+		h[0] |= 0x80
+	}
+	if rsv1 {
+		h[0] |= 0x40
+	}
+	n := 2
+	switch size := len(payload); {
+	case size <= 125:
+		h[1] = byte(size)
+	case size <= 0xffff:
+		h[1] = 126
+		binary.BigEndian.PutUint16(h[2:], uint16(size))
+		n = 4
+	default:
+		h[1] = 127
+		binary.BigEndian.PutUint64(h[2:], uint64(size))
+		n = 10
+	}
+	_, err := (&net.Buffers{h[:n], payload}).WriteTo(c.conn)
+	return err
+}
+
+var flateWriterPool = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+var flateReaderPool = sync.Pool{
+	New: func() any {
+		return flate.NewReader(nil)
+	},
+}
+
+type wsWriterFunc func(p []byte) (int, error)
+
+func (f wsWriterFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// wsMessageWriter writes a message in fragments.
+type wsMessageWriter struct {
+	c        *WSConn
+	opcode   int
+	compress bool
+	fw       *flate.Writer
+	buf      []byte
+	err      error
+	closed   bool
+}
+
+func (w *wsMessageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrWSClosed
+	}
+	if w.fw != nil {
+		return w.fw.Write(p)
+	}
+	return w.write(p)
+}
+
+// write buffers the (compressed) payload and sends the full frames.
+func (w *wsMessageWriter) write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf = append(w.buf, p...)
+	// the tail of the deflate stream is kept to be removed at the end of the message
+	for len(w.buf) > wsFrameSize+len(wsDeflateTail) {
+		w.flushFrame(w.buf[:wsFrameSize], false)
+		if w.err != nil {
+			return 0, w.err
+		}
+		w.buf = append(w.buf[:0], w.buf[wsFrameSize:]...)
+	}
+	return len(p), nil
+}
+
+func (w *wsMessageWriter) flushFrame(payload []byte, fin bool) {
+	w.err = w.c.writeFrame(w.opcode, fin, w.compress, payload)
+	// the continuation frames have no opcode and reserved bits
+	w.opcode = 0
+	w.compress = false
+}
+
+// Close sends the final frame of the message.
+func (w *wsMessageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.c.writeLock.Unlock()
+
+	if w.fw != nil {
+		err := w.fw.Flush()
+		flateWriterPool.Put(w.fw)
+		w.fw = nil
+		if err != nil && w.err == nil {
+			w.err = err
+		}
+		if w.err == nil {
+			w.buf = bytes.TrimSuffix(w.buf, []byte(wsDeflateTail))
+		}
+	}
+	if w.err == nil {
+		w.flushFrame(w.buf, true)
+	}
+	return w.err
+}
+
+// serve upgrades the request and runs the handler with the connection.
+func (ws *webSocket) serve(ctx *Context) {
+	r := ctx.R
+	if r.Method != "GET" {
+		ctx.respondWithError(&invalid{405, "websocket: the request method must be GET"})
+		return
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		ctx.respondWithError(&invalid{400, "websocket: not a websocket handshake"})
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		ctx.header.Set("Sec-WebSocket-Version", "13")
+		ctx.respondWithError(&invalid{426, "websocket: unsupported version"})
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+		ctx.respondWithError(&invalid{400, "websocket: invalid Sec-WebSocket-Key"})
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !ws.checkOrigin(r, origin) {
+		ctx.respondWithError(&invalid{403, "websocket: origin not allowed"})
+		return
+	}
+
+	h, ok := ctx.W.(http.Hijacker)
+	if !ok {
+		ctx.respondWithError(errors.New("websocket: the response writer does not implement the http.Hijacker"))
+		return
+	}
+	netConn, brw, err := h.Hijack()
+	if err != nil {
+		ctx.respondWithError(err)
+		return
+	}
+	if wr, ok := ctx.W.(*rexWriter); ok {
+		wr.code = http.StatusSwitchingProtocols
+		wr.isHeaderSent = true
+	}
+	// clear the deadlines set by the server
+	netConn.SetDeadline(time.Time{})
+
+	conn := &WSConn{
+		conn:        netConn,
+		br:          brw.Reader,
+		request:     r,
+		subprotocol: selectSubprotocol(r, ws.opts.Subprotocols),
+		readLimit:   ws.opts.ReadLimit,
+	}
+	if conn.readLimit <= 0 {
+		conn.readLimit = defaultMaxBodySize
+	}
+
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	buf.WriteString("Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n")
+	if conn.subprotocol != "" {
+		buf.WriteString("Sec-WebSocket-Protocol: " + conn.subprotocol + "\r\n")
+	}
+	if ws.opts.Compress && acceptPermessageDeflate(r.Header) {
+		conn.compress = true
+		buf.WriteString("Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+	}
+	// the headers set by the middlewares, e.g. Set-Cookie
+	for k, vv := range ctx.header {
+		switch k {
+		case "Connection", "Upgrade", "Content-Length", "Content-Type", "Transfer-Encoding", "Vary":
+			continue
+		}
+		if strings.HasPrefix(k, "Sec-Websocket-") {
+			continue
+		}
+		for _, v := range vv {
+			buf.WriteString(k + ": " + strings.NewReplacer("\r", "", "\n", "").Replace(v) + "\r\n")
+		}
+	}
+	buf.WriteString("\r\n")
+	if _, err = netConn.Write(buf.Bytes()); err != nil {
+		netConn.Close()
+		return
+	}
+
+	err = ws.fn(conn)
+	var ce *CloseError
+	var pe *wsProtocolError
+	switch {
+	case err == nil:
+		conn.finish(CloseNormalClosure, "")
+	case errors.As(err, &pe):
+		// the connection is closed with the protocol error code
+		conn.finish(pe.code, "")
+	case errors.As(err, &ce), errors.Is(err, ErrWSClosed), errors.Is(err, net.ErrClosed):
+		conn.finish(CloseNormalClosure, "")
+	default:
+		ctx.logf("error", "%s", err.Error())
+		conn.finish(CloseInternalServerErr, "")
+	}
+}
+
+// checkOrigin checks the Origin header with the Cors options, or the host of the request.
+func (ws *webSocket) checkOrigin(r *http.Request, origin string) bool {
+	if ws.opts.Cors != nil {
+		return ws.opts.Cors.allowOrigin(r, origin)
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// wsAcceptKey computes the Sec-WebSocket-Accept value of the key.
+func wsAcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// selectSubprotocol selects the first subprotocol of the client that is supported.
+func selectSubprotocol(r *http.Request, supported []string) string {
+	if len(supported) == 0 {
+		return ""
+	}
+	for _, v := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			p = strings.TrimSpace(p)
+			for _, s := range supported {
+				if p == s {
+					return s
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// acceptPermessageDeflate reports whether the client offers a permessage-deflate
+// extension that can be accepted. The server always disables the context takeover,
+// and the server window must not be limited since the compress/flate package
+// uses the max window size.
+func acceptPermessageDeflate(header http.Header) bool {
+	for _, v := range header.Values("Sec-WebSocket-Extensions") {
+		for _, offer := range strings.Split(v, ",") {
+			params := strings.Split(offer, ";")
+			if strings.TrimSpace(params[0]) != "permessage-deflate" {
+				continue
+			}
+			ok := true
+			for _, param := range params[1:] {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				value = strings.Trim(strings.TrimSpace(value), `"`)
+				switch strings.TrimSpace(name) {
+				case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+				case "server_max_window_bits":
+					ok = ok && value == "15"
+				default:
+					ok = false
+				}
+			}
+			if ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headerContainsToken reports whether the comma-separated header values contain the token.
+func headerContainsToken(header http.Header, name string, token string) bool {
+	for _, v := range header.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validCloseCode reports whether the close code can be sent in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
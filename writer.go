@@ -6,6 +6,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"sync"
 )
 
 // A Writer implements the http.ResponseWriter interface.
@@ -16,13 +17,20 @@ type rexWriter struct {
 	writeN       int
 	rawWriter    http.ResponseWriter
 	zWriter      io.WriteCloser
+	zPool        *sync.Pool
+	hijacked     bool
 }
 
 // Hijack lets the caller take over the connection.
 func (w *rexWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	h, ok := w.rawWriter.(http.Hijacker)
 	if ok {
-		return h.Hijack()
+		conn, rw, err := h.Hijack()
+		if err == nil {
+			// the connection is detached from the writer
+			w.hijacked = true
+		}
+		return conn, rw, err
 	}
 
 	return nil, nil, errors.New("the raw response writer does not implement the http.Hijacker")
@@ -30,12 +38,20 @@ func (w *rexWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 
 // Flush sends any buffered data to the client.
 func (w *rexWriter) Flush() {
+	if w.hijacked {
+		return
+	}
 	f, ok := w.rawWriter.(http.Flusher)
 	if ok {
 		f.Flush()
 	}
 }
 
+// Unwrap returns the raw response writer, it's used by the http.ResponseController.
+func (w *rexWriter) Unwrap() http.ResponseWriter {
+	return w.rawWriter
+}
+
 // Header returns the header map that will be sent by WriteHeader.
 func (w *rexWriter) Header() http.Header {
 	return w.rawWriter.Header()
@@ -43,7 +59,7 @@ func (w *rexWriter) Header() http.Header {
 
 // WriteHeader sends a HTTP response header with the provided status code.
 func (w *rexWriter) WriteHeader(code int) {
-	if !w.isHeaderSent {
+	if !w.isHeaderSent && !w.hijacked {
 		w.rawWriter.WriteHeader(code)
 		w.code = code
 		w.isHeaderSent = true
@@ -52,6 +68,9 @@ func (w *rexWriter) WriteHeader(code int) {
 
 // Write writes the data to the connection as part of an HTTP reply.
 func (w *rexWriter) Write(p []byte) (n int, err error) {
+	if w.hijacked {
+		return 0, http.ErrHijacked
+	}
 	if !w.isHeaderSent {
 		w.isHeaderSent = true
 	}
@@ -68,8 +87,16 @@ func (w *rexWriter) Write(p []byte) (n int, err error) {
 
 // Close closes the underlying connection.
 func (w *rexWriter) Close() error {
-	if w.zWriter != nil {
-		return w.zWriter.Close()
+	if w.zWriter != nil && !w.hijacked {
+		err := w.zWriter.Close()
+		if enc, ok := w.zWriter.(compressEncoder); ok && w.zPool != nil {
+			// put the encoder back to the pool
+			enc.Reset(io.Discard)
+			w.zPool.Put(enc)
+		}
+		w.zWriter = nil
+		w.zPool = nil
+		return err
 	}
 	return nil
 }